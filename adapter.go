@@ -4,11 +4,14 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"unicode"
 )
 
+const INT_MAX = 2147483647
 const UINT_MAX = 4294967295
+const LONG_MAX = 9223372036854775807
 
 type stream struct {
 	buf []byte
@@ -24,12 +27,20 @@ func initStdin() {
 
 func newStream(fp *os.File) *stream {
 	s := &stream{}
-	s.buf = make([]byte, 1024*1024) // @TODO. use real file size
-	fp.Read(s.buf)
+	buf, err := io.ReadAll(fp)
+	if err != nil {
+		errorf("%s", err)
+	}
+	s.buf = append(buf, byte(0)) // add EOF
 	s.fp = fp
 	return s
 }
 
+// newStringStream makes a stream that reads from s.
+func newStringStream(s string) *stream {
+	return &stream{buf: append([]byte(s), byte(0))}
+}
+
 func (s *stream) getc() (byte, error) {
 	b := s.buf[s.i]
 	if b == byte(0) {
@@ -72,6 +83,10 @@ func isalnum(c byte) bool {
 	return isalpha(c) || byte('0') <= c && c <= byte('9')
 }
 
+func isxdigit(c byte) bool {
+	return byte('0') <= c && c <= byte('9') || (byte('a') <= c && c <= byte('f')) || (byte('A') <= c && c <= byte('F'))
+}
+
 func printf(format string, args ...interface{}) {
 	fmt.Printf(format, args...)
 }
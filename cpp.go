@@ -3,11 +3,16 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 var macros = make(map[string]*Macro)
 var cond_incl_stack = make([]*CondIncl, 0)
 var std_include_path []string
+
+// The directories given by -I, searched before std_include_path
+var user_include_path []string
 var cpp_token_zero = &Token{typ: TTYPE_NUMBER, sval: "0"}
 var cpp_token_one = &Token{typ: TTYPE_NUMBER, sval: "1"}
 
@@ -38,22 +43,25 @@ type Macro struct {
 }
 
 func eval(buf string){
-	bytes := append([]byte(buf), byte(0)) // add EOF
-	fp := &stream{
-		buf:  bytes,
-		i:0,
-	}
-	set_input_file("(eval)", fp)
+	orig := file
+	set_input_file("(eval)", newStringStream(buf))
 	toplevels := read_toplevels()
 	for _, ast := range toplevels {
-		printf("# ast=%s\n", ast)
+		fmt.Fprintf(outfp, "# ast=%s\n", ast)
 		emit_toplevel(ast)
 	}
-	set_input_file("(stdin)", stdin)
+	file = orig
+	at_bol = true
 }
 
 func initCpp() {
+	// Our own headers such as stdarg.h are next to the executable.
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
 	std_include_path = []string{
+		filepath.Join(filepath.Dir(exe), "include"),
 		"/usr/local/include",
 		"/usr/include/x86_64-linux-gnu",
 		"/usr/lib/gcc/x86_64-linux-gnu/7/include",
@@ -65,7 +73,9 @@ func initCpp() {
 	macros["__x86_64__"] = make_obj_marco([]*Token{cpp_token_one})
 	macros["__8cc__"] = make_obj_marco([]*Token{cpp_token_one})
 	macros["__STDC__"] = make_obj_marco([]*Token{cpp_token_one})
-	eval("typedef int __builtin_va_list[1];")
+	// The va_list of the System V AMD64 ABI.
+	eval("typedef struct { unsigned int gp_offset; unsigned int fp_offset;" +
+		" void *overflow_arg_area; void *reg_save_area; } __builtin_va_list[1];")
 }
 
 func make_cond_incl(ctx CondInclCtx, wastrue bool) *CondIncl {
@@ -185,7 +195,7 @@ func read_funclike_macro_args(param *Dict) bool {
 		if tok == nil || tok.typ == TTYPE_NEWLINE {
 			errorf("missing ')' in macro parameter list")
 		}
-		if tok.is_ident("...") {
+		if tok.is_punct(OP_ELLIPSIS) {
 			param.PutToken("__VA_ARGS__", make_macro_token(pos))
 			pos++
 			expect(')')
@@ -324,10 +334,8 @@ func add_hide_set(tokens TokenList, hideset *Dict) TokenList {
 
 func paste(s string, tok *Token) string {
 	switch tok.typ {
-	case TTYPE_IDENT, TTYPE_NUMBER:
+	case TTYPE_IDENT, TTYPE_NUMBER, TTYPE_PUNCT:
 		return s + tok.sval
-	case TTYPE_PUNCT:
-		return s + fmt.Sprintf("%c", tok.punct)
 	default:
 		errorf("can't paste: %s", tok)
 	}
@@ -339,15 +347,29 @@ func glue_tokens(t0 *Token, t1 *Token) *Token {
 	s = paste(s, t0)
 	s = paste(s, t1)
 	r := copy_token(t0)
-	if isdigit(s[0]) {
+	if punct, ok := lookup_punct(s); ok {
+		r.typ = TTYPE_PUNCT
+		r.punct = punct
+	} else if isdigit(s[0]) || s[0] == '.' && len(s) > 1 && isdigit(s[1]) {
 		r.typ = TTYPE_NUMBER
-	} else {
+	} else if is_ident_string(s) {
 		r.typ = TTYPE_IDENT
+	} else {
+		errorf("pasting %s and %s does not give a valid preprocessing token", t0, t1)
 	}
 	r.sval = s
 	return r
 }
 
+func is_ident_string(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isalnum(s[i]) && s[i] != '_' {
+			return false
+		}
+	}
+	return !isdigit(s[0])
+}
+
 func glue_push(tokens TokenList, tok *Token) TokenList {
 	assert(len(tokens) > 0)
 	last := tokens[len(tokens)-1]
@@ -362,14 +384,12 @@ func join_tokens(args TokenList) string {
 			s += " "
 		}
 		switch tok.typ {
-		case TTYPE_IDENT, TTYPE_NUMBER:
+		case TTYPE_IDENT, TTYPE_NUMBER, TTYPE_PUNCT:
 			s += tok.sval
-		case TTYPE_PUNCT:
-			s += fmt.Sprintf("%c", tok.punct)
 		case TTYPE_CHAR:
-			s += quote_char(tok.c)
+			s += fmt.Sprintf("%s'%s'", enc_prefix(tok.enc), tok.sval)
 		case TTYPE_STRING:
-			s += fmt.Sprintf("\"%s\"", tok.sval)
+			s += fmt.Sprintf("%s\"%s\"", enc_prefix(tok.enc), tok.sval)
 		default:
 			errorf("internal error")
 		}
@@ -380,7 +400,7 @@ func join_tokens(args TokenList) string {
 func stringize(args TokenList) *Token {
 	r := &Token{
 		typ:  TTYPE_STRING,
-		sval: join_tokens(args),
+		sval: quote_cstring(join_tokens(args)),
 	}
 	return r
 }
@@ -414,7 +434,7 @@ func subst(macro *Macro, args []TokenList, hideset *Dict) TokenList {
 			i++
 			continue
 		}
-		if t0.is_ident("##") && t1_param {
+		if t0.is_punct(OP_HASHHASH) && t1_param {
 			arg := args[t1.position]
 			if len(arg) > 0 {
 				r = glue_push(r, arg[0])
@@ -425,13 +445,13 @@ func subst(macro *Macro, args []TokenList, hideset *Dict) TokenList {
 			i++
 			continue
 		}
-		if t0.is_ident("##") && !islast {
+		if t0.is_punct(OP_HASHHASH) && !islast {
 			hideset = t1.hideset
 			r = glue_push(r, t1)
 			i++
 			continue
 		}
-		if t0_param && !islast && t1.is_ident("##") {
+		if t0_param && !islast && t1.is_punct(OP_HASHHASH) {
 			hideset = t1.hideset
 			arg := args[t0.position]
 			if len(arg) == 0 {
@@ -623,11 +643,11 @@ func read_include() {
 	name,std := read_cpp_header_name()
 	expect_newline()
 	var paths []string
-	if std {
-		paths = std_include_path
-	} else {
+	if !std {
 		paths = []string{""}
 	}
+	paths = append(paths, user_include_path...)
+	paths = append(paths, std_include_path...)
 
 	for _, directory := range paths {
 		path := construct_path(directory, name)
@@ -669,6 +689,26 @@ func read_print() {
 	}
 	fmt.Fprintf(os.Stderr, "%s\n", tok)
 }
+// preprocess writes the tokens of the input after preprocessing to
+// outfp. A token at the beginning of a line starts a new line.
+func preprocess() {
+	first := true
+	for {
+		tok := read_token_int2(false)
+		if tok == nil {
+			break
+		}
+		if tok.bol && !first {
+			fmt.Fprintln(outfp)
+		} else if tok.space {
+			fmt.Fprint(outfp, " ")
+		}
+		fmt.Fprint(outfp, tok)
+		first = false
+	}
+	fmt.Fprintln(outfp)
+}
+
 func read_directive() {
 	tok := read_cpp_token()
 	if tok.is_ident("define") {
@@ -740,5 +780,44 @@ func read_token() *Token {
 	assert(!r.is_newline())
 	assert(r.typ != TTYPE_SPACE)
 	assert(r.typ != TTYPE_MACRO_PARAM)
+	if r.typ == TTYPE_STRING {
+		return concat_strings(r)
+	}
 	return r
 }
+
+// concat_strings concatenates the string literals following tok
+// into a new token. The result has the encoding of the literals with
+// a prefix, in which the characters of all of them are decoded.
+func concat_strings(tok *Token) *Token {
+	if tok.chars != nil {
+		// Already concatenated
+		return tok
+	}
+	toks := []*Token{tok}
+	enc := tok.enc
+	for {
+		next := read_token_int2(false)
+		if next == nil || next.typ != TTYPE_STRING {
+			unget_token(next)
+			break
+		}
+		if next.enc != ENC_NONE {
+			if enc != ENC_NONE && enc != next.enc {
+				errorf("Unsupported concatenation of %s and %s", toks[len(toks)-1], next)
+			}
+			enc = next.enc
+		}
+		toks = append(toks, next)
+	}
+	r := *tok
+	r.enc = enc
+	r.chars = []int{}
+	var svals []string
+	for _, t := range toks {
+		r.chars = append(r.chars, decode_literal(t.sval, enc)...)
+		svals = append(svals, t.sval)
+	}
+	r.sval = strings.Join(svals, "\" \"")
+	return &r
+}
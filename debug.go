@@ -6,6 +6,11 @@ func (ctype *Ctype) c2s_int(dict map[string]bool) string {
 	if ctype == nil {
 		return "(nil)"
 	}
+	if is_inttype(ctype) && !ctype.sig {
+		t := *ctype
+		t.sig = true
+		return "unsigned " + t.c2s_int(dict)
+	}
 	switch ctype.typ {
 	case CTYPE_VOID:
 		return "void"
@@ -74,6 +79,33 @@ func binop_to_string(op string, ast *Ast) string {
 	return format("(%s %s %s)", op, ast.left, ast.right)
 }
 
+// assign_lvalue returns the lvalue of a compound assignment
+// or an increment/decrement as written in the source.
+func assign_lvalue(ast *Ast) *Ast {
+	if ast.init != nil {
+		return ast.init.declinit.operand
+	}
+	return ast.left
+}
+
+func inc_dec_to_string(op string, ast *Ast) string {
+	return format("(%s %s)", op, assign_lvalue(ast))
+}
+
+func assign_op_to_string(ast *Ast) string {
+	lvalue := assign_lvalue(ast)
+	var op string
+	switch ast.typ {
+	case OP_A_SAL:
+		op = "<<="
+	case OP_A_SAR:
+		op = ">>="
+	default:
+		op = format("%c=", assign_op_binop(ast.typ))
+	}
+	return format("(%s %s %s)", op, lvalue, ast.right.right)
+}
+
 func (ast *Ast) String() string {
 	if ast == nil {
 		return "(nil)"
@@ -92,17 +124,30 @@ func (ast *Ast) String() string {
 				return format("'%c'", ast.ival)
 			}
 		case CTYPE_INT:
+			if !ast.ctype.sig {
+				return format("%dU", ast.ival)
+			}
 			return format("%d", ast.ival)
 		case CTYPE_LONG:
+			if !ast.ctype.sig {
+				return format("%dUL", uint64(ast.ival))
+			}
 			return format("%dL", ast.ival)
+		case CTYPE_LLONG:
+			if !ast.ctype.sig {
+				return format("%dULL", uint64(ast.ival))
+			}
+			return format("%dLL", ast.ival)
 		case CTYPE_FLOAT, CTYPE_DOUBLE:
 			return format("%f", ast.fval)
+		case CTYPE_LDOUBLE:
+			return format("%sL", ast.lval.Text('f', 6))
 		default:
 			errorf("internal error")
 			return ""
 		}
 	case AST_STRING:
-		return format("\"%s\"", quote_cstring(ast.val))
+		return format("%s\"%s\"", enc_prefix(ast.enc), quote_chars(ast.chars, ast.enc))
 	case AST_LVAR:
 		return format("%s", ast.varname)
 	case AST_GVAR:
@@ -117,6 +162,22 @@ func (ast *Ast) String() string {
 		}
 		s += ")"
 		return s
+	case AST_FUNCPTR_CALL:
+		s := format("(%s)(*%s)(", ast.ctype, ast.fptr)
+		for i, v := range ast.args {
+			s += v.String()
+			if i < len(ast.args)-1 {
+				s += ","
+			}
+		}
+		s += ")"
+		return s
+	case AST_FUNCDESG:
+		return ast.fname
+	case AST_VA_START:
+		return format("(va_start %s)", ast.operand)
+	case AST_VA_ARG:
+		return format("(va_arg %s %s)", ast.operand, ast.ctype)
 	case AST_FUNC:
 		s := format("(%s)%s(",
 			ast.ctype,
@@ -155,6 +216,8 @@ func (ast *Ast) String() string {
 		}
 		s += "}"
 		return s
+	case AST_INIT:
+		return ast.operand.String()
 	case AST_IF:
 		s := format("(if %s %s",
 			ast.cond,
@@ -176,6 +239,24 @@ func (ast *Ast) String() string {
 			ast.step,
 			ast.body)
 		return s
+	case AST_WHILE:
+		return format("(while %s %s)", ast.cond, ast.body)
+	case AST_DO:
+		return format("(do %s %s)", ast.cond, ast.body)
+	case AST_BREAK:
+		return "(break)"
+	case AST_CONTINUE:
+		return "(continue)"
+	case AST_SWITCH:
+		return format("(switch %s %s)", ast.cond, ast.body)
+	case AST_CASE:
+		return format("(case %d %s)", ast.ival, ast.body)
+	case AST_DEFAULT:
+		return format("(default %s)", ast.body)
+	case AST_GOTO:
+		return format("(goto %s)", ast.labelname)
+	case AST_LABEL:
+		return format("(label %s %s)", ast.labelname, ast.body)
 	case AST_RETURN:
 		return format("(return %s)", ast.retval)
 	case AST_COMPOUND_STMT:
@@ -188,9 +269,15 @@ func (ast *Ast) String() string {
 		return s
 	case AST_STRUCT_REF:
 		s := ast.struc.String()
+		if ast.field == "" {
+			// An anonymous member
+			return s
+		}
 		s += "."
 		s += ast.field
 		return s
+	case OP_CAST:
+		return format("((%s) %s)", ast.ctype, ast.operand)
 	case AST_ADDR:
 		return uop_to_string("addr", ast)
 	case AST_DEREF:
@@ -201,16 +288,29 @@ func (ast *Ast) String() string {
 		return binop_to_string("<=", ast)
 	case OP_NE:
 		return binop_to_string("!=", ast)
-	case OP_INC:
-		return uop_to_string("++", ast)
-	case OP_DEC:
-		return uop_to_string("--", ast)
+	case OP_PRE_INC:
+		return inc_dec_to_string("pre++", ast)
+	case OP_PRE_DEC:
+		return inc_dec_to_string("pre--", ast)
+	case OP_POST_INC:
+		return inc_dec_to_string("post++", ast)
+	case OP_POST_DEC:
+		return inc_dec_to_string("post--", ast)
 	case OP_LOGAND:
 		return binop_to_string("and", ast)
 	case OP_LOGOR:
 		return binop_to_string("or", ast)
+	case OP_A_ADD, OP_A_SUB, OP_A_MUL, OP_A_DIV, OP_A_MOD,
+		OP_A_AND, OP_A_OR, OP_A_XOR, OP_A_SAL, OP_A_SAR:
+		return assign_op_to_string(ast)
+	case OP_SAL:
+		return binop_to_string("<<", ast)
+	case OP_SAR:
+		return binop_to_string(">>", ast)
 	case '!':
 		return uop_to_string("!", ast)
+	case '~':
+		return uop_to_string("~", ast)
 	case '&':
 		return binop_to_string("&", ast)
 	case '|':
@@ -237,18 +337,13 @@ func (tok *Token) String() string {
 	case TTYPE_IDENT:
 		return tok.sval
 	case TTYPE_PUNCT:
-		if tok.is_punct(OP_EQ) {
-			return "=="
-		} else if tok.is_punct(OP_NE) {
-			return "!="
-		}
-		return format("%c", tok.punct)
+		return tok.sval
 	case TTYPE_CHAR:
-		return format("%c", tok.c)
+		return format("%s'%s'", enc_prefix(tok.enc), tok.sval)
 	case TTYPE_NUMBER:
 		return tok.sval
 	case TTYPE_STRING:
-		return format("\"%s\"", tok.sval)
+		return format("%s\"%s\"", enc_prefix(tok.enc), tok.sval)
 	case TTYPE_NEWLINE:
 		return "(newline)"
 	case TTYPE_SPACE:
@@ -79,6 +79,16 @@ func (dict *Dict) PutToken(key string, val *Token) {
 	})
 }
 
+// GetLocal looks up key in dict only, not in its parents.
+func (dict *Dict) GetLocal(key string) *DictValue {
+	for _, e := range dict.list {
+		if e.key == key {
+			return e.val
+		}
+	}
+	return nil
+}
+
 func (dict *Dict) Put(key string, val *DictValue) {
 	e := &DictEntry{
 		key: key,
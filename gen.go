@@ -1,14 +1,62 @@
 package main
 
+import "io"
+import "os"
 import "unsafe"
 import "runtime"
 import "fmt"
 import "strings"
+import "math"
+import "math/big"
 
 var REGS = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
 
 var stackpos int
 
+// The file the assembly is written to
+var outfp io.Writer = os.Stdout
+
+// The offset of the saved address of a returned struct
+// passed by the caller.
+var retbuf_off int
+
+// A variadic function saves the argument registers to the register
+// save area at va_area_off. The other variables tell where va_start
+// finds the first argument not taken by the named parameters.
+const VA_REG_SAVE_AREA_SIZE = 176
+
+var va_area_off int
+var va_gp_offset int
+var va_fp_offset int
+var va_stack_off int
+
+// Jump targets of the innermost enclosing loops and switch
+// statements, used by break and continue statements.
+type LoopLabels struct {
+	lcontinue string
+	lbreak    string
+}
+
+var loop_stack []*LoopLabels
+
+func push_loop(lcontinue string, lbreak string) {
+	loop_stack = append(loop_stack, &LoopLabels{
+		lcontinue: lcontinue,
+		lbreak:    lbreak,
+	})
+}
+
+func pop_loop() {
+	loop_stack = loop_stack[:len(loop_stack)-1]
+}
+
+func current_loop() *LoopLabels {
+	if len(loop_stack) == 0 {
+		return nil
+	}
+	return loop_stack[len(loop_stack)-1]
+}
+
 func emit(format string, args ...interface{}) {
 	emit_int("\t"+format, args...)
 }
@@ -26,7 +74,7 @@ func emit_int(format string, args ...interface{}) {
 	callerName := (strings.Split(details.Name(), "."))[1]
 	caller := fmt.Sprintf(" %s %d", callerName, no)
 	numSpaces := 27 - len(code)
-	printf("%s %*c %s\n", code, numSpaces, '#', caller)
+	fmt.Fprintf(outfp, "%s %*c %s\n", code, numSpaces, '#', caller)
 }
 
 func get_int_reg(ctype *Ctype, r byte) string {
@@ -86,81 +134,311 @@ func pop(reg string) {
 	assert(stackpos >= 8)
 }
 
+// emit_load loads a value of the given type from addr. Integers are
+// sign or zero extended to 64 bits, so that %rax always holds the
+// value of an integer expression as a 64-bit number.
+func emit_load(ctype *Ctype, addr string) {
+	switch ctype.typ {
+	case CTYPE_ARRAY, CTYPE_STRUCT:
+		// The value of an array or a struct is its address.
+		emit("lea %s, %%rax", addr)
+	case CTYPE_FLOAT:
+		emit("movss %s, %%xmm0", addr)
+	case CTYPE_DOUBLE:
+		emit("movsd %s, %%xmm0", addr)
+	case CTYPE_LDOUBLE:
+		emit("fldt %s", addr)
+	default:
+		switch {
+		case ctype.size == 1 && ctype.sig:
+			emit("movsbq %s, %%rax", addr)
+		case ctype.size == 1:
+			emit("movzbq %s, %%rax", addr)
+		case ctype.size == 2 && ctype.sig:
+			emit("movswq %s, %%rax", addr)
+		case ctype.size == 2:
+			emit("movzwq %s, %%rax", addr)
+		case ctype.size == 4 && ctype.sig:
+			emit("movslq %s, %%rax", addr)
+		default:
+			emit("mov %s, %%%s", addr, get_int_reg(ctype, 'a'))
+		}
+	}
+}
+
+// emit_store stores the value of the given type in %rax, %xmm0 or
+// %st(0) to addr. A long double stays on the x87 register stack.
+func emit_store(ctype *Ctype, addr string) {
+	switch ctype.typ {
+	case CTYPE_FLOAT:
+		emit("movss %%xmm0, %s", addr)
+	case CTYPE_DOUBLE:
+		emit("movsd %%xmm0, %s", addr)
+	case CTYPE_LDOUBLE:
+		emit("fld %%st(0)")
+		emit("fstpt %s", addr)
+	default:
+		emit("mov %%%s, %s", get_int_reg(ctype, 'a'), addr)
+	}
+}
+
 func emit_gload(ctype *Ctype, label string, off int) {
-	if ctype.typ == CTYPE_ARRAY {
-		if off != 0 {
-			emit("lea %s+%d(%%rip), %%rax", label, off)
+	if off != 0 {
+		emit_load(ctype, format("%s+%d(%%rip)", label, off))
+	} else {
+		emit_load(ctype, format("%s(%%rip)", label))
+	}
+}
+
+// emit_intcast extends the low ctype.size bytes of %rax to 64 bits
+// according to the signedness of ctype.
+func emit_intcast(ctype *Ctype) {
+	switch ctype.size {
+	case 1:
+		if ctype.sig {
+			emit("movsbq %%al, %%rax")
 		} else {
-			emit("lea %s(%%rip), %%rax", label)
+			emit("movzbq %%al, %%rax")
+		}
+	case 2:
+		if ctype.sig {
+			emit("movswq %%ax, %%rax")
+		} else {
+			emit("movzwq %%ax, %%rax")
+		}
+	case 4:
+		if ctype.sig {
+			emit("movslq %%eax, %%rax")
+		} else {
+			emit("mov %%eax, %%eax")
 		}
-		return
 	}
-	reg := get_int_reg(ctype, 'a')
-	if ctype.size < 4 {
-		emit("mov $0, %%eax")
+}
+
+// emit_int_to_flo converts the integer in %rax to a floating point
+// number of type to in %xmm0.
+func emit_int_to_flo(to *Ctype, from *Ctype) {
+	suffix := "sd"
+	if to.typ == CTYPE_FLOAT {
+		suffix = "ss"
 	}
-	if off != 0 {
-		emit("mov %s+%d(%%rip), %%%s", label, off, reg)
+	if from.size == 8 && !from.sig {
+		// cvtsi2s[sd] only knows signed integers. Halve values
+		// too large for them, keeping the lowest bit for rounding.
+		big := make_label()
+		end := make_label()
+		emit("test %%rax, %%rax")
+		emit("js %s", big)
+		emit("cvtsi2%sq %%rax, %%xmm0", suffix)
+		emit("jmp %s", end)
+		emit("%s:", big)
+		emit("mov %%rax, %%rcx")
+		emit("shr %%rcx")
+		emit("and $1, %%eax")
+		emit("or %%rax, %%rcx")
+		emit("cvtsi2%sq %%rcx, %%xmm0", suffix)
+		emit("add%s %%xmm0, %%xmm0", suffix)
+		emit("%s:", end)
 	} else {
-		emit("mov %s(%%rip), %%%s", label, reg)
+		emit("cvtsi2%sq %%rax, %%xmm0", suffix)
 	}
 }
 
-func emit_toint(ctype *Ctype) {
-	if !is_flotype(ctype) {
+// emit_flo_to_int converts the floating point number of type from in
+// %xmm0 to an integer of type to in %rax.
+func emit_flo_to_int(to *Ctype, from *Ctype) {
+	suffix := flo_suffix(from)
+	if to.size == 8 && !to.sig {
+		// cvtts[sd]2si only knows signed integers. Subtract 2^63
+		// from values too large for them and add it back later.
+		big := make_label()
+		end := make_label()
+		if from.typ == CTYPE_FLOAT {
+			emit("mov $0x5f000000, %%eax")
+		} else {
+			emit("mov $0x43e0000000000000, %%rax")
+		}
+		emit("movq %%rax, %%xmm1")
+		emit("ucomi%s %%xmm1, %%xmm0", suffix)
+		emit("jae %s", big)
+		emit("cvtt%s2si %%xmm0, %%rax", suffix)
+		emit("jmp %s", end)
+		emit("%s:", big)
+		emit("sub%s %%xmm1, %%xmm0", suffix)
+		emit("cvtt%s2si %%xmm0, %%rax", suffix)
+		emit("btc $63, %%rax")
+		emit("%s:", end)
 		return
 	}
-	emit("cvttsd2si %%xmm0, %%eax")
+	emit("cvtt%s2si %%xmm0, %%rax", suffix)
+	emit_intcast(to)
 }
 
-func emit_todouble(ctype *Ctype) {
-	if is_flotype(ctype) {
+// emit_ldouble_to_int converts the long double in %st(0) to an integer
+// of type to in %rax.
+func emit_ldouble_to_int(to *Ctype) {
+	push("rax")
+	if to.size == 8 && !to.sig {
+		// fisttpq only knows signed integers. Subtract 2^63 from
+		// values too large for them and add it back later.
+		big := make_label()
+		end := make_label()
+		emit("movl $0x5f000000, (%%rsp)")
+		emit("flds (%%rsp)")
+		emit("fucomip %%st(1), %%st")
+		emit("jbe %s", big)
+		emit("fisttpq (%%rsp)")
+		emit("jmp %s", end)
+		emit("%s:", big)
+		emit("fsubs (%%rsp)")
+		emit("fisttpq (%%rsp)")
+		emit("btcq $63, (%%rsp)")
+		emit("%s:", end)
+		pop("rax")
 		return
 	}
-	emit("cvtsi2sd %%eax, %%xmm0")
+	emit("fisttpq (%%rsp)")
+	pop("rax")
+	emit_intcast(to)
 }
 
-func emit_lload(ctype *Ctype, off int) {
-	if ctype.typ == CTYPE_ARRAY {
-		emit("lea %d(%%rbp), %%rax", off)
-	} else if ctype.typ == CTYPE_FLOAT {
-		emit("cvtps2pd %d(%%rbp), %%xmm0", off)
-	} else if ctype.typ == CTYPE_DOUBLE || ctype.typ == CTYPE_LDOUBLE {
-		emit("movsd %d(%%rbp), %%xmm0", off)
+// emit_int_to_ldouble converts the integer of type from in %rax to a
+// long double in %st(0).
+func emit_int_to_ldouble(from *Ctype) {
+	push("rax")
+	emit("fildq (%%rsp)")
+	if from.size == 8 && !from.sig {
+		// fildq only knows signed integers. Add 2^64 to the
+		// values read as negative.
+		end := make_label()
+		emit("test %%rax, %%rax")
+		emit("jns %s", end)
+		emit("movl $0x5f800000, (%%rsp)")
+		emit("fadds (%%rsp)")
+		emit("%s:", end)
+	}
+	pop("rax")
+}
+
+// emit_flo_ldouble converts between a long double in %st(0) and a
+// float or double in %xmm0, through the memory.
+func emit_flo_ldouble(from *Ctype, to *Ctype) {
+	push_xmm(0)
+	if to.typ == CTYPE_LDOUBLE {
+		emit("fld%s (%%rsp)", choose(from.typ == CTYPE_FLOAT, "s", "l"))
 	} else {
-		reg := get_int_reg(ctype, 'a')
-		if ctype.size < 4 {
-			emit("mov $0, %%eax")
+		emit("fstp%s (%%rsp)", choose(to.typ == CTYPE_FLOAT, "s", "l"))
+	}
+	pop_xmm(0)
+}
+
+// flo_suffix returns the suffix of the SSE instructions for the
+// floating point type: ss for float and sd for double.
+func flo_suffix(ctype *Ctype) string {
+	if ctype.typ == CTYPE_FLOAT {
+		return "ss"
+	}
+	return "sd"
+}
+
+// emit_conv converts the value in %rax or %xmm0 from type from
+// to type to.
+func emit_conv(from *Ctype, to *Ctype) {
+	from = convert_array(from)
+	switch {
+	case to.typ == CTYPE_VOID:
+		emit_discard(from)
+	case !is_scalar(to) || !is_scalar(from) || to.typ == from.typ && is_flotype(to):
+		return
+	case from.typ == CTYPE_LDOUBLE && is_flotype(to), to.typ == CTYPE_LDOUBLE && is_flotype(from):
+		emit_flo_ldouble(from, to)
+	case is_flotype(from) && is_flotype(to):
+		if to.typ == CTYPE_FLOAT {
+			emit("cvtsd2ss %%xmm0, %%xmm0")
+		} else {
+			emit("cvtss2sd %%xmm0, %%xmm0")
 		}
-		emit("mov %d(%%rbp), %%%s", off, reg)
+	case from.typ == CTYPE_LDOUBLE:
+		emit_ldouble_to_int(to)
+	case to.typ == CTYPE_LDOUBLE:
+		emit_int_to_ldouble(from)
+	case is_flotype(from):
+		emit_flo_to_int(to, from)
+	case is_flotype(to):
+		emit_int_to_flo(to, from)
+	case to.size < 8 && (to.size != from.size || to.sig != from.sig):
+		emit_intcast(to)
 	}
 }
 
-func emit_gsave(varname string, ctype *Ctype, off int) {
+func emit_cast(ast *Ast) {
+	emit_expr(ast.operand)
+	emit_conv(ast.operand.ctype, ast.ctype)
+}
+
+func emit_lload(ctype *Ctype, off int) {
+	emit_load(ctype, format("%d(%%rbp)", off))
+}
+
+func emit_gsave(label string, ctype *Ctype, off int) {
 	assert(ctype.typ != CTYPE_ARRAY)
-	reg := get_int_reg(ctype, 'a')
 	if off != 0 {
-		emit("mov %%%s, %s+%d(%%rip)", reg, varname, off)
+		emit_store(ctype, format("%s+%d(%%rip)", label, off))
 	} else {
-		emit("mov %%%s, %s(%%rip)", reg, varname)
+		emit_store(ctype, format("%s(%%rip)", label))
 	}
 }
 
 func emit_lsave(ctype *Ctype, off int) {
-	if ctype.typ == CTYPE_FLOAT {
+	emit_store(ctype, format("%d(%%rbp)", off))
+}
+
+// push_value pushes the value of the given type in %rax, %xmm0 or
+// %st(0). A long double takes 16 bytes.
+func push_value(ctype *Ctype) {
+	switch {
+	case ctype.typ == CTYPE_LDOUBLE:
+		emit("sub $16, %%rsp")
+		emit("fstpt (%%rsp)")
+		stackpos += 16
+	case is_flotype(ctype):
 		push_xmm(0)
-		emit("cvtpd2ps %%xmm0, %%xmm0")
-		emit("movss %%xmm0, %d(%%rbp)", off)
+	default:
+		push("rax")
+	}
+}
+
+// pop_value pops a value pushed by push_value.
+func pop_value(ctype *Ctype) {
+	switch {
+	case ctype.typ == CTYPE_LDOUBLE:
+		emit("fldt (%%rsp)")
+		emit("add $16, %%rsp")
+		stackpos -= 16
+	case is_flotype(ctype):
 		pop_xmm(0)
-	} else if ctype.typ == CTYPE_DOUBLE || ctype.typ == CTYPE_LDOUBLE {
-		emit("movsd %%xmm0, %d(%%rbp)", off)
-	} else {
-		reg := get_int_reg(ctype, 'a')
-		emit("mov %%%s, %d(%%rbp)", reg, off)
+	default:
+		pop("rax")
+	}
+}
+
+// emit_discard pops an unused long double from the x87 register stack.
+// The values of the other types need not be discarded.
+func emit_discard(ctype *Ctype) {
+	if ctype.typ == CTYPE_LDOUBLE {
+		emit("fstp %%st(0)")
 	}
 }
 
+// emit_assign_deref_int stores the value pushed by push_value to
+// off(%rax), and pops it back.
 func emit_assign_deref_int(ctype *Ctype, off int) {
+	if is_flotype(ctype) {
+		emit("mov %%rax, %%rcx")
+		pop_value(ctype)
+		emit_store(ctype, format("%d(%%rcx)", off))
+		return
+	}
 	emit("mov (%%rsp), %%rcx")
 	reg := get_int_reg(ctype, 'c')
 	if off != 0 {
@@ -172,12 +450,12 @@ func emit_assign_deref_int(ctype *Ctype, off int) {
 }
 
 func emit_assign_deref(variable *Ast) {
-	push("rax")
+	push_value(variable.ctype)
 	emit_expr(variable.operand)
 	emit_assign_deref_int(variable.operand.ctype.ptr, 0)
 }
 
-func emit_pointer_arith(_ byte, left *Ast, right *Ast) {
+func emit_pointer_arith(op int, left *Ast, right *Ast) {
 	emit_expr(left)
 	push("rax")
 	emit_expr(right)
@@ -187,7 +465,11 @@ func emit_pointer_arith(_ byte, left *Ast, right *Ast) {
 	}
 	emit("mov %%rax, %%rcx")
 	pop("rax")
-	emit("add %%rcx, %%rax")
+	if op == '-' {
+		emit("sub %%rcx, %%rax")
+	} else {
+		emit("add %%rcx, %%rax")
+	}
 }
 
 func emit_assign_struct_ref(struc *Ast, field *Ctype, off int) {
@@ -195,12 +477,12 @@ func emit_assign_struct_ref(struc *Ast, field *Ctype, off int) {
 	case AST_LVAR:
 		emit_lsave(field, struc.loff+field.offset+off)
 	case AST_GVAR:
-		emit_gsave(struc.varname, field, field.offset+off)
+		emit_gsave(struc.glabel, field, field.offset+off)
 	case AST_STRUCT_REF:
 		emit_assign_struct_ref(struc.struc, field, off+struc.ctype.offset)
 	case AST_DEREF:
 		v := struc
-		push("rax")
+		push_value(field)
 		emit_expr(v.operand)
 		emit_assign_deref_int(field, field.offset+off)
 	default:
@@ -216,12 +498,81 @@ func emit_load_struct_ref(struc *Ast, field *Ctype, off int) {
 		emit_gload(field, struc.glabel, field.offset+off)
 	case AST_STRUCT_REF:
 		emit_load_struct_ref(struc.struc, field, struc.ctype.offset+off)
+	default:
+		emit_addr(struc)
+		emit_load_deref(field, field.offset+off)
+	}
+}
+
+// emit_bitfield_extract extracts a bit-field from its unit in %rax,
+// extending it to 64 bits by the signedness of its type.
+func emit_bitfield_extract(ctype *Ctype) {
+	emit("shl $%d, %%rax", 64-ctype.bitoff-ctype.bitsize)
+	emit("%s $%d, %%rax", choose(ctype.sig, "sar", "shr"), 64-ctype.bitsize)
+}
+
+// emit_assign_bitfield stores the value in %rax to a bit-field by
+// replacing its bits in the unit, and leaves the stored value in %rax.
+func emit_assign_bitfield(variable *Ast) {
+	emit_bitfield_position(variable.ctype)
+	push("rax")
+	emit_addr(variable)
+	emit_bitfield_store(variable.ctype)
+}
+
+// emit_bitfield_position truncates the value in %rax to the width of
+// a bit-field and shifts it to the position of the bit-field.
+func emit_bitfield_position(ctype *Ctype) {
+	emit("shl $%d, %%rax", 64-ctype.bitsize)
+	emit("shr $%d, %%rax", 64-ctype.bitsize-ctype.bitoff)
+}
+
+// emit_bitfield_store pops a value made by emit_bitfield_position and
+// stores it to the unit at (%rax).
+func emit_bitfield_store(ctype *Ctype) {
+	emit("mov %%rax, %%r11")
+	emit_load(ctype, "(%r11)")
+	mask := (1<<uint(ctype.bitsize) - 1) << uint(ctype.bitoff)
+	emit("mov $%d, %%rcx", ^mask)
+	emit("and %%rcx, %%rax")
+	pop("rcx")
+	emit("or %%rcx, %%rax")
+	emit("mov %%%s, (%%r11)", get_int_reg(ctype, 'a'))
+	emit_bitfield_extract(ctype)
+}
+
+// emit_addr computes the address of an lvalue into %rax.
+func emit_addr(ast *Ast) {
+	switch ast.typ {
+	case AST_LVAR:
+		emit("lea %d(%%rbp), %%rax", ast.loff)
+	case AST_GVAR:
+		emit("lea %s(%%rip), %%rax", ast.glabel)
+	case AST_FUNCDESG:
+		emit("lea %s(%%rip), %%rax", ast.fname)
 	case AST_DEREF:
-		emit_expr(struc.operand)
-		emit_load_deref(struc.ctype, field, field.offset+off)
+		emit_expr(ast.operand)
+	case AST_STRUCT_REF:
+		emit_addr(ast.struc)
+		if ast.ctype.offset != 0 {
+			emit("add $%d, %%rax", ast.ctype.offset)
+		}
 	default:
-		errorf("internal error: %s", struc)
+		if ast.ctype.typ != CTYPE_STRUCT {
+			errorf("internal error: %s", ast)
+		}
+		// A struct returned from a function or an assignment.
+		emit_expr(ast)
+	}
+}
+
+func emit_assign_op(ast *Ast) {
+	if ast.init != nil {
+		emit_expr(ast.init)
 	}
+	emit_expr(ast.right)
+	emit_conv(ast.right.ctype, ast.ctype)
+	emit_assign(ast.left)
 }
 
 func emit_assign(variable *Ast) {
@@ -233,38 +584,140 @@ func emit_assign(variable *Ast) {
 	case AST_DEREF:
 		emit_assign_deref(variable)
 	case AST_STRUCT_REF:
+		if variable.ctype.bitsize > 0 {
+			emit_assign_bitfield(variable)
+			return
+		}
 		emit_assign_struct_ref(variable.struc, variable.ctype, 0)
 	case AST_LVAR:
 		emit_lsave(variable.ctype, variable.loff)
 	case AST_GVAR:
-		emit_gsave(variable.varname, variable.ctype, 0)
+		emit_gsave(variable.glabel, variable.ctype, 0)
 	default:
 		errorf("internal error")
 	}
 }
 
-func emit_comp(inst string, ast *Ast) {
-	if is_flotype(ast.ctype) {
-		emit_expr(ast.left)
-		emit_todouble(ast.left.ctype)
-		push_xmm(0)
-		emit_expr(ast.right)
-		emit_todouble(ast.right.ctype)
-		pop_xmm(1)
-		emit("ucomisd %%xmm0, %%xmm1")
+// emit_comp compares two integers or pointers after converting them
+// to their common type, which decides if the comparison is signed.
+func emit_comp(ast *Ast) {
+	if is_flotype(ast.left.ctype) || is_flotype(ast.right.ctype) {
+		emit_flo_comp(ast)
+		return
+	}
+	ctype := result_type(ast.typ, ast.left.ctype, ast.right.ctype)
+	emit_expr(ast.left)
+	emit_conv(ast.left.ctype, ctype)
+	push("rax")
+	emit_expr(ast.right)
+	emit_conv(ast.right.ctype, ctype)
+	pop("rcx")
+	emit("cmp %%rax, %%rcx")
+	var inst string
+	switch ast.typ {
+	case '<':
+		inst = choose(ctype.sig, "setl", "setb")
+	case '>':
+		inst = choose(ctype.sig, "setg", "seta")
+	case OP_LE:
+		inst = choose(ctype.sig, "setle", "setbe")
+	case OP_GE:
+		inst = choose(ctype.sig, "setge", "setae")
+	case OP_EQ:
+		inst = "sete"
+	case OP_NE:
+		inst = "setne"
+	default:
+		errorf("internal error")
+	}
+	emit("%s %%al", inst)
+	emit("movzb %%al, %%eax")
+}
+
+func choose(sig bool, signed string, unsigned string) string {
+	if sig {
+		return signed
+	}
+	return unsigned
+}
+
+// emit_flo_comp compares two floating point numbers in their common
+// type. ucomis[sd] and fucomip set the flags like an unsigned
+// comparison, and additionally set ZF, PF and CF if either operand is
+// NaN, so "<" and "<=" are computed as ">" and ">=" with swapped
+// operands to make them false for NaN.
+func emit_flo_comp(ast *Ast) {
+	ctype := usual_arith_conv(ast.left.ctype, ast.right.ctype)
+	rev := ast.typ == '<' || ast.typ == OP_LE
+	emit_expr(ast.left)
+	emit_conv(ast.left.ctype, ctype)
+	push_value(ctype)
+	emit_expr(ast.right)
+	emit_conv(ast.right.ctype, ctype)
+	if ctype.typ == CTYPE_LDOUBLE {
+		// %st(0) is the left operand and %st(1) the right.
+		pop_value(ctype)
+		if rev {
+			emit("fxch %%st(1)")
+		}
+		emit("fucomip %%st(1), %%st")
+		emit("fstp %%st(0)")
 	} else {
-		emit_expr(ast.left)
-		emit_toint(ast.left.ctype)
-		push("rax")
-		emit_expr(ast.right)
-		emit_toint(ast.right.ctype)
-		pop("rcx")
-		emit("cmp %%rax, %%rcx")
-		emit("%s %%al", inst)
+		pop_xmm(1)
+		ucomi := "ucomi" + flo_suffix(ctype)
+		if rev {
+			emit("%s %%xmm1, %%xmm0", ucomi)
+		} else {
+			emit("%s %%xmm0, %%xmm1", ucomi)
+		}
+	}
+	switch ast.typ {
+	case '<', '>':
+		emit("seta %%al")
+	case OP_LE, OP_GE:
+		emit("setae %%al")
+	case OP_EQ:
+		emit("sete %%al")
+		emit("setnp %%cl")
+		emit("and %%cl, %%al")
+	case OP_NE:
+		emit("setne %%al")
+		emit("setp %%cl")
+		emit("or %%cl, %%al")
+	default:
+		errorf("internal error")
+	}
+	emit("movzb %%al, %%eax")
+}
+
+// emit_cond computes a scalar condition and sets %eax to 1 if it is
+// not zero, or to 0 otherwise. A NaN is not zero. A long double is
+// popped from the x87 register stack.
+func emit_cond(ast *Ast) {
+	emit_expr(ast)
+	switch ast.ctype.typ {
+	case CTYPE_FLOAT, CTYPE_DOUBLE:
+		emit("xorps %%xmm1, %%xmm1")
+		emit("ucomi%s %%xmm1, %%xmm0", flo_suffix(ast.ctype))
+	case CTYPE_LDOUBLE:
+		emit("fldz")
+		emit("fucomip %%st(1), %%st")
+		emit("fstp %%st(0)")
+	default:
+		emit("test %%rax, %%rax")
+		emit("setne %%al")
 		emit("movzb %%al, %%eax")
+		return
 	}
+	emit("setne %%al")
+	emit("setp %%cl")
+	emit("or %%cl, %%al")
+	emit("movzb %%al, %%eax")
 }
 
+// emit_bion_int_arith computes an integer operation in the type of
+// its result, to which both operands are converted first. The result
+// is extended to 64 bits again like any other integer value.
 func emit_bion_int_arith(ast *Ast) {
 	var op string
 	switch ast.typ {
@@ -274,103 +727,121 @@ func emit_bion_int_arith(ast *Ast) {
 		op = "sub"
 	case '*':
 		op = "imul"
-	case '/':
+	case '^':
+		op = "xor"
+	case '&':
+		op = "and"
+	case '|':
+		op = "or"
+	case OP_SAL:
+		op = "sal"
+	case OP_SAR:
+		op = choose(ast.ctype.sig, "sar", "shr")
+	case '/', '%':
 		break
 	default:
 		errorf("invalid operator '%d", ast.typ)
 	}
 
 	emit_expr(ast.left)
-	emit_toint(ast.left.ctype)
+	emit_conv(ast.left.ctype, ast.ctype)
 	push("rax")
 	emit_expr(ast.right)
-	emit_toint(ast.right.ctype)
+	if ast.typ != OP_SAL && ast.typ != OP_SAR {
+		emit_conv(ast.right.ctype, ast.ctype)
+	}
 	emit("mov %%rax, %%rcx")
 	pop("rax")
-	if ast.typ == '/' {
-		emit("mov $0, %%edx")
-		emit("idiv %%rcx")
-	} else {
+	switch ast.typ {
+	case '/', '%':
+		emit_intdiv(ast.ctype)
+		if ast.typ == '%' {
+			emit("mov %%rdx, %%rax")
+		}
+	case OP_SAL, OP_SAR:
+		emit("%s %%cl, %%%s", op, get_int_reg(ast.ctype, 'a'))
+	default:
 		emit("%s %%rcx, %%rax", op)
 	}
+	emit_intcast(ast.ctype)
+}
+
+// emit_intdiv divides %rax by %rcx, leaving the quotient
+// in %rax and the remainder in %rdx.
+func emit_intdiv(ctype *Ctype) {
+	if ctype.size == 8 {
+		if ctype.sig {
+			emit("cqto")
+			emit("idiv %%rcx")
+		} else {
+			emit("mov $0, %%edx")
+			emit("div %%rcx")
+		}
+		return
+	}
+	if ctype.sig {
+		emit("cltd")
+		emit("idiv %%ecx")
+	} else {
+		emit("mov $0, %%edx")
+		emit("div %%ecx")
+	}
 }
 
 func emit_binop_float_arith(ast *Ast) {
 	var op string
 	switch ast.typ {
 	case '+':
-		op = "addsd"
+		op = "add"
 	case '-':
-		op = "subsd"
+		op = "sub"
 	case '*':
-		op = "mulsd"
+		op = "mul"
 	case '/':
-		op = "divsd"
+		op = "div"
 	default:
 		errorf("invalid operator '%d'", ast.typ)
 	}
 	emit_expr(ast.left)
-	emit_todouble(ast.left.ctype)
-	push_xmm(0)
+	emit_conv(ast.left.ctype, ast.ctype)
+	push_value(ast.ctype)
 	emit_expr(ast.right)
-	emit_todouble(ast.right.ctype)
+	emit_conv(ast.right.ctype, ast.ctype)
+	if ast.ctype.typ == CTYPE_LDOUBLE {
+		// %st(0) is the left operand and %st(1) the right.
+		pop_value(ast.ctype)
+		emit("f%s %%st(1), %%st", op)
+		emit("fstp %%st(1)")
+		return
+	}
 	emit("movsd %%xmm0, %%xmm1")
 	pop_xmm(0)
-	emit("%s %%xmm1, %%xmm0", op)
-}
-
-func emit_load_convert(to *Ctype, from *Ctype) {
-	if is_flotype(to) {
-		emit_todouble(from)
-	} else {
-		emit_toint(from)
-	}
-}
-
-func emit_save_convert(to *Ctype, from *Ctype) {
-	if is_inttype(from) && to.typ == CTYPE_FLOAT {
-		emit("cvtsi2ss %%eax, %%xmm0")
-	} else if is_flotype(from) && to.typ == CTYPE_FLOAT {
-		emit("cvtpd2ps %%xmm0, %%xmm0")
-	} else if is_inttype(from) && (to.typ == CTYPE_DOUBLE || to.typ == CTYPE_LDOUBLE) {
-		emit("cvtsi2sd %%eax, %%xmm0")
-	} else if !(is_flotype(from) && (to.typ == CTYPE_DOUBLE || to.typ == CTYPE_LDOUBLE)) {
-		emit_load_convert(to, from)
-	}
+	emit("%s%s %%xmm1, %%xmm0", op, flo_suffix(ast.ctype))
 }
 
 func emit_binop(ast *Ast) {
+	if ast.typ == '=' && ast.ctype.typ == CTYPE_STRUCT {
+		emit_expr(ast.right)
+		push("rax")
+		emit_addr(ast.left)
+		pop("rcx")
+		emit_copy_struct(ast.ctype.size)
+		return
+	}
 	if ast.typ == '=' {
 		emit_expr(ast.right)
-		emit_load_convert(ast.ctype, ast.right.ctype)
+		emit_conv(ast.right.ctype, ast.ctype)
 		emit_assign(ast.left)
 		return
 	}
 	if ast.ctype.typ == CTYPE_PTR {
-		emit_pointer_arith(byte(ast.typ), ast.left, ast.right)
+		emit_pointer_arith(ast.typ, ast.left, ast.right)
 		return
 	}
-	switch ast.typ {
-	case '<':
-		emit_comp("setl", ast)
-		return
-	case '>':
-		emit_comp("setg", ast)
-		return
-	case OP_EQ:
-		emit_comp("sete", ast)
-		return
-	case OP_GE:
-		emit_comp("setge", ast)
-		return
-	case OP_LE:
-		emit_comp("setle", ast)
-		return
-	case OP_NE:
-		emit_comp("setne", ast)
+	if is_comparison(ast.typ) {
+		emit_comp(ast)
 		return
 	}
-
 	if is_inttype(ast.ctype) {
 		emit_bion_int_arith(ast)
 	} else if is_flotype(ast.ctype) {
@@ -380,170 +851,525 @@ func emit_binop(ast *Ast) {
 	}
 }
 
-func emit_inc_dec(ast *Ast, op string) {
-	emit_expr(ast.operand)
-	push("rax")
-	emit("%s $1, %%rax", op)
-	emit_assign(ast.operand)
-	pop("rax")
+func emit_post_inc_dec(ast *Ast) {
+	if ast.init != nil {
+		emit_expr(ast.init)
+	}
+	emit_expr(ast.left)
+	push_value(ast.ctype)
+	emit_expr(ast.right)
+	emit_conv(ast.right.ctype, ast.ctype)
+	emit_assign(ast.left)
+	emit_discard(ast.ctype)
+	pop_value(ast.ctype)
 }
 
-func emit_load_deref(result_type *Ctype, operand_type *Ctype, off int) {
-	if operand_type.typ == CTYPE_PTR &&
-		operand_type.ptr.typ == CTYPE_ARRAY {
+func emit_load_deref(ctype *Ctype, off int) {
+	if ctype.typ == CTYPE_ARRAY || ctype.typ == CTYPE_STRUCT || ctype.typ == CTYPE_FUNC {
+		if off != 0 {
+			emit("add $%d, %%rax", off)
+		}
 		return
 	}
-	if result_type.size < 4 {
-		emit("mov $0, %%ecx")
-	}
-	reg := get_int_reg(result_type, 'c')
 	if off != 0 {
-		emit("mov %d(%%rax), %%%s", off, reg)
+		emit_load(ctype, format("%d(%%rax)", off))
 	} else {
-		emit("mov (%%rax), %%%s", reg)
+		emit_load(ctype, "(%rax)")
 	}
-	emit("mov %%rcx, %%rax")
+}
+
+// A switch statement is lowered to a jump table if it has at least
+// JUMP_TABLE_MIN_CASES cases and the range of the case values is
+// at most JUMP_TABLE_DENSITY times the number of cases.
+// Otherwise a chain of comparisons is emitted.
+const JUMP_TABLE_MIN_CASES = 4
+const JUMP_TABLE_DENSITY = 3
 
+func case_range(cases []*Ast) (int, int) {
+	min := cases[0].ival
+	max := cases[0].ival
+	for _, c := range cases {
+		if c.ival < min {
+			min = c.ival
+		}
+		if c.ival > max {
+			max = c.ival
+		}
+	}
+	return min, max
 }
 
-func get_arg_types(ast *Ast) []*Ctype {
-	var r []*Ctype
-	for i, v := range ast.args {
-		var ptype *Ctype
-		if len(ast.paramtypes) > i {
-			ptype = ast.paramtypes[i]
+func use_jump_table(cases []*Ast) bool {
+	if len(cases) < JUMP_TABLE_MIN_CASES {
+		return false
+	}
+	min, max := case_range(cases)
+	span := uint64(max) - uint64(min)
+	return span < uint64(len(cases)*JUMP_TABLE_DENSITY)
+}
+
+func emit_case_compare(ctype *Ctype, cases []*Ast) {
+	for _, c := range cases {
+		if ctype.size == 8 {
+			emit("mov $%d, %%rcx", c.ival)
+			emit("cmp %%rcx, %%rax")
 		} else {
-			ptype = result_type('=', v.ctype, ctype_int)
+			emit("cmp $%d, %%eax", c.ival)
 		}
-		r = append(r, ptype)
+		emit("je %s", c.label)
 	}
-	return r
 }
-func emit_expr(ast *Ast) {
-	switch ast.typ {
-	case AST_LITERAL:
-		switch ast.ctype.typ {
-		case CTYPE_CHAR:
-			emit("mov $%d, %%rax", ast.ival)
-		case CTYPE_INT:
-			emit("mov $%d, %%eax", ast.ival)
-		case CTYPE_LONG:
-			emit("mov $%d, %%rax", ast.ival)
-		case CTYPE_FLOAT, CTYPE_DOUBLE, CTYPE_LDOUBLE:
-			emit("movsd %s(%%rip), %%xmm0", ast.flabel)
-		default:
-			errorf("internal error")
-		}
-	case AST_STRING:
-		emit("lea %s(%%rip), %%rax", ast.slabel)
-	case AST_LVAR:
-		emit_lload(ast.ctype, ast.loff)
-	case AST_GVAR:
-		emit_gload(ast.ctype, ast.glabel, 0)
-	case AST_FUNCALL:
-		ireg := 0
-		xreg := 0
-		argtypes := get_arg_types(ast)
-		for _, v := range argtypes {
-			if is_flotype(v) {
-				if xreg > 0 {
-					push_xmm(xreg)
-				}
-				xreg++
-			} else {
-				push(REGS[ireg])
-				ireg++
+
+func emit_jump_table(ctype *Ctype, cases []*Ast, deflabel string) {
+	switch {
+	case ctype.size == 8:
+	case ctype.sig || ctype.size < 4:
+		emit("movslq %%eax, %%rax")
+	default:
+		emit("mov %%eax, %%eax")
+	}
+	min, max := case_range(cases)
+	emit("mov $%d, %%rcx", min)
+	emit("sub %%rcx, %%rax")
+	emit("cmp $%d, %%rax", max-min)
+	emit("ja %s", deflabel)
+	table := make_label()
+	emit("lea %s(%%rip), %%rcx", table)
+	emit("jmp *(%%rcx,%%rax,8)")
+
+	labels := make([]string, max-min+1)
+	for _, c := range cases {
+		labels[c.ival-min] = c.label
+	}
+	emit(".section .rodata")
+	emit(".align 8")
+	emit_label("%s:", table)
+	for _, label := range labels {
+		if label == "" {
+			label = deflabel
+		}
+		emit(".quad %s", label)
+	}
+	emit(".text")
+}
+
+func emit_switch(ast *Ast) {
+	end := make_label()
+	deflabel := end
+	if ast.defcase != nil {
+		deflabel = ast.defcase.label
+	}
+	emit_expr(ast.cond)
+	if len(ast.cases) > 0 {
+		if use_jump_table(ast.cases) {
+			emit_jump_table(ast.cond.ctype, ast.cases, deflabel)
+		} else {
+			emit_case_compare(ast.cond.ctype, ast.cases)
+		}
+	}
+	emit("jmp %s", deflabel)
+
+	lcontinue := ""
+	if loop := current_loop(); loop != nil {
+		lcontinue = loop.lcontinue
+	}
+	push_loop(lcontinue, end)
+	emit_expr(ast.body)
+	pop_loop()
+	emit("%s:", end)
+}
+
+// default_arg_type returns the type of an argument passed without a
+// prototype, after the default argument promotions.
+func default_arg_type(ctype *Ctype) *Ctype {
+	ctype = convert_array(ctype)
+	if ctype.typ == CTYPE_FLOAT {
+		return ctype_double
+	}
+	if is_inttype(ctype) {
+		return promote_int(ctype)
+	}
+	return ctype
+}
+
+func get_arg_types(ast *Ast) []*Ctype {
+	var r []*Ctype
+	for i, v := range ast.args {
+		var ptype *Ctype
+		if len(ast.paramtypes) > i {
+			ptype = ast.paramtypes[i]
+		} else {
+			ptype = default_arg_type(v.ctype)
+		}
+		r = append(r, ptype)
+	}
+	return r
+}
+// Classes of eightbytes in the System V AMD64 ABI. A value of up to
+// two eightbytes is passed in general purpose registers (INTEGER) or
+// SSE registers (SSE) by its eightbytes, and otherwise on the stack.
+const (
+	CLASS_NO = iota
+	CLASS_INTEGER
+	CLASS_SSE
+	CLASS_MEMORY
+)
+
+const NUM_XMM_REGS = 8
+
+func classify(ctype *Ctype) []int {
+	if ctype.typ == CTYPE_LDOUBLE {
+		// Long double arguments are passed in memory. They are
+		// returned in %st(0), which the callers know without this.
+		return []int{CLASS_MEMORY}
+	}
+	if is_flotype(ctype) {
+		return []int{CLASS_SSE}
+	}
+	if ctype.typ != CTYPE_STRUCT {
+		return []int{CLASS_INTEGER}
+	}
+	if ctype.size > 16 || ctype.size == 0 {
+		return []int{CLASS_MEMORY}
+	}
+	classes := make([]int, (ctype.size+7)/8)
+	classify_fields(classes, ctype, 0)
+	for i, c := range classes {
+		if c == CLASS_MEMORY {
+			return []int{CLASS_MEMORY}
+		}
+		if c == CLASS_NO {
+			classes[i] = CLASS_SSE
+		}
+	}
+	return classes
+}
+
+// classify_fields merges the classes of the scalars in a struct at
+// offset off into classes. INTEGER wins over SSE in an eightbyte.
+func classify_fields(classes []int, ctype *Ctype, off int) {
+	switch ctype.typ {
+	case CTYPE_STRUCT:
+		for _, v := range ctype.fields.Values() {
+			if v.ctype.bitsize == 0 {
+				// A zero-width bit-field has no storage.
+				continue
 			}
+			classify_fields(classes, v.ctype, off+v.ctype.offset)
 		}
-		for i, v := range ast.args {
-			emit_expr(v)
-			ptype := argtypes[i]
-			emit_save_convert(ptype, v.ctype)
-			if is_flotype(ptype) {
-				push_xmm(0)
-			} else {
-				push("rax")
+	case CTYPE_ARRAY:
+		for i := 0; i < ctype.len; i++ {
+			classify_fields(classes, ctype.ptr, off+i*ctype.ptr.size)
+		}
+	case CTYPE_LDOUBLE:
+		classes[off/8] = CLASS_MEMORY
+	default:
+		i := off / 8
+		if is_flotype(ctype) {
+			if classes[i] == CLASS_NO {
+				classes[i] = CLASS_SSE
+			}
+		} else {
+			classes[i] = CLASS_INTEGER
+		}
+	}
+}
+
+func is_memory_class(ctype *Ctype) bool {
+	return classify(ctype)[0] == CLASS_MEMORY
+}
+
+// ArgLoc is where an argument is passed: in the registers for its
+// eightbytes, or if regs is nil at stackoff in the stack argument area.
+type ArgLoc struct {
+	regs     []string
+	stackoff int
+}
+
+// assign_args decides where arguments of the given types are passed.
+// ireg integer registers are already taken. It returns the locations,
+// the size of the stack argument area and the numbers of general
+// purpose and SSE registers used.
+func assign_args(ctypes []*Ctype, ireg int) ([]ArgLoc, int, int, int) {
+	xreg := 0
+	stacksize := 0
+	locs := make([]ArgLoc, len(ctypes))
+	for i, ctype := range ctypes {
+		classes := classify(ctype)
+		nint := 0
+		nsse := 0
+		for _, c := range classes {
+			if c == CLASS_INTEGER {
+				nint++
+			} else if c == CLASS_SSE {
+				nsse++
 			}
 		}
-		ir := ireg
-		xr := xreg
-		var reversed_args []*Ctype
-		for i := len(argtypes) - 1; i >= 0; i-- {
-			reversed_args = append(reversed_args, argtypes[i])
+		if classes[0] == CLASS_MEMORY ||
+			ireg+nint > len(REGS) || xreg+nsse > NUM_XMM_REGS {
+			if ctype.align > 8 {
+				stacksize = align(stacksize, 16)
+			}
+			locs[i].stackoff = stacksize
+			stacksize += align(ctype.size, 8)
+			continue
 		}
-		for _, v := range reversed_args {
-			if is_flotype(v) {
-				xr--
-				pop_xmm(xr)
+		for _, c := range classes {
+			if c == CLASS_INTEGER {
+				locs[i].regs = append(locs[i].regs, REGS[ireg])
+				ireg++
 			} else {
-				ir--
-				pop(REGS[ir])
+				locs[i].regs = append(locs[i].regs, format("xmm%d", xreg))
+				xreg++
 			}
 		}
-		emit("mov $%d, %%eax", xreg)
-		if stackpos%16 != 0 {
-			emit("sub $8, %%rsp")
+	}
+	return locs, stacksize, ireg, xreg
+}
+
+// emit_copy_struct copies size bytes from (%rcx) to (%rax).
+func emit_copy_struct(size int) {
+	i := 0
+	for ; i+8 <= size; i += 8 {
+		emit("mov %d(%%rcx), %%r11", i)
+		emit("mov %%r11, %d(%%rax)", i)
+	}
+	for ; i+4 <= size; i += 4 {
+		emit("mov %d(%%rcx), %%r11d", i)
+		emit("mov %%r11d, %d(%%rax)", i)
+	}
+	for ; i < size; i++ {
+		emit("mov %d(%%rcx), %%r11b", i)
+		emit("mov %%r11b, %d(%%rax)", i)
+	}
+}
+
+// emit_push_struct pushes a copy of the struct at (%rax),
+// padded to a multiple of 8 bytes.
+func emit_push_struct(ctype *Ctype) {
+	size := align(ctype.size, 8)
+	emit("sub $%d, %%rsp", size)
+	stackpos += size
+	emit("mov %%rax, %%rcx")
+	emit("mov %%rsp, %%rax")
+	emit_copy_struct(ctype.size)
+}
+
+func emit_load_reg(reg string, addr string) {
+	if strings.HasPrefix(reg, "xmm") {
+		emit("movsd %s, %%%s", addr, reg)
+	} else {
+		emit("mov %s, %%%s", addr, reg)
+	}
+}
+
+func emit_save_reg(reg string, addr string) {
+	if strings.HasPrefix(reg, "xmm") {
+		emit("movsd %%%s, %s", reg, addr)
+	} else {
+		emit("mov %%%s, %s", reg, addr)
+	}
+}
+
+// emit_load_eightbyte loads n bytes at off(%rcx) into %r11 without
+// reading past them, as a struct may end in the middle of an eightbyte.
+func emit_load_eightbyte(off int, n int) {
+	switch n {
+	case 8:
+		emit("mov %d(%%rcx), %%r11", off)
+	case 4:
+		emit("mov %d(%%rcx), %%r11d", off)
+	default:
+		emit("xor %%r11d, %%r11d")
+		for i := n - 1; i >= 0; i-- {
+			emit("shl $8, %%r11")
+			emit("movzbq %d(%%rcx), %%r10", off+i)
+			emit("or %%r10, %%r11")
+		}
+	}
+}
+
+// emit_func_call evaluates the arguments onto the stack from left to
+// right, then moves them to their registers or the stack argument
+// area at the bottom of the stack, and calls the function.
+func emit_func_call(ast *Ast) {
+	argtypes := get_arg_types(ast)
+	retmem := ast.ctype.typ == CTYPE_STRUCT && is_memory_class(ast.ctype)
+	ireg := 0
+	if retmem {
+		// The address of the returned struct is passed in %rdi.
+		ireg = 1
+	}
+	locs, stacksize, _, xreg := assign_args(argtypes, ireg)
+
+	base := stackpos
+	if ast.typ == AST_FUNCPTR_CALL {
+		emit_expr(ast.fptr)
+		push("rax")
+	}
+	var slots []int
+	for i, v := range ast.args {
+		emit_expr(v)
+		ptype := argtypes[i]
+		if ptype.typ == CTYPE_STRUCT {
+			emit_push_struct(ptype)
+		} else {
+			emit_conv(v.ctype, ptype)
+			push_value(ptype)
+		}
+		slots = append(slots, stackpos)
+	}
+	// %rsp must be aligned to 16 bytes at the call.
+	size := align(stackpos+stacksize, 16) - stackpos
+	if size > 0 {
+		emit("sub $%d, %%rsp", size)
+		stackpos += size
+	}
+	for i, loc := range locs {
+		if loc.regs != nil {
+			continue
+		}
+		for j := 0; j < argtypes[i].size; j += 8 {
+			emit("mov %d(%%rsp), %%r11", stackpos-slots[i]+j)
+			emit("mov %%r11, %d(%%rsp)", loc.stackoff+j)
+		}
+	}
+	for i, loc := range locs {
+		for j, reg := range loc.regs {
+			emit_load_reg(reg, format("%d(%%rsp)", stackpos-slots[i]+j*8))
 		}
+	}
+	if retmem {
+		emit("lea %d(%%rbp), %%rdi", ast.retbuf.loff)
+	}
+	emit("mov $%d, %%eax", xreg)
+	if ast.typ == AST_FUNCPTR_CALL {
+		emit("mov %d(%%rsp), %%r11", stackpos-base-8)
+		emit("call *%%r11")
+	} else {
 		emit("call %s", ast.fname)
-		if stackpos%16 != 0 {
-			emit("add $8, %%rsp")
-		}
-		for _, v := range reversed_args {
-			if is_flotype(v) {
-				if xreg != 1 {
-					xreg--
-					pop_xmm(xreg)
-				}
-			} else {
-				ireg--
-				pop(REGS[ireg])
-			}
+	}
+	if stackpos != base {
+		emit("add $%d, %%rsp", stackpos-base)
+		stackpos = base
+	}
+	switch {
+	case ast.ctype.typ == CTYPE_STRUCT:
+		if !retmem {
+			emit_save_struct_regs(ast.ctype, ast.retbuf.loff)
+		}
+		emit("lea %d(%%rbp), %%rax", ast.retbuf.loff)
+	case is_inttype(ast.ctype):
+		// The upper bits of a returned integer are undefined.
+		emit_intcast(ast.ctype)
+	}
+}
+
+// emit_save_struct_regs stores a struct returned in registers
+// to off(%rbp).
+func emit_save_struct_regs(ctype *Ctype, off int) {
+	iregs := []string{"rax", "rdx"}
+	xreg := 0
+	for i, c := range classify(ctype) {
+		addr := format("%d(%%rbp)", off+i*8)
+		if c == CLASS_SSE {
+			emit("movsd %%xmm%d, %s", xreg, addr)
+			xreg++
+		} else {
+			emit("mov %%%s, %s", iregs[0], addr)
+			iregs = iregs[1:]
+		}
+	}
+}
+
+// emit_return_struct returns the struct at (%rax), either in registers
+// or by copying it to the buffer the caller passed.
+func emit_return_struct(ctype *Ctype) {
+	emit("mov %%rax, %%rcx")
+	if is_memory_class(ctype) {
+		emit("mov %d(%%rbp), %%rax", retbuf_off)
+		emit_copy_struct(ctype.size)
+		return
+	}
+	iregs := []string{"rax", "rdx"}
+	xreg := 0
+	for i, c := range classify(ctype) {
+		n := ctype.size - i*8
+		if n > 8 {
+			n = 8
 		}
-		if ast.ctype.typ == CTYPE_FLOAT {
-			emit("cvtps2pd %%xmm0, %%xmm0")
+		emit_load_eightbyte(i*8, n)
+		if c == CLASS_SSE {
+			emit("movq %%r11, %%xmm%d", xreg)
+			xreg++
+		} else {
+			emit("mov %%r11, %%%s", iregs[0])
+			iregs = iregs[1:]
 		}
+	}
+}
+
+func emit_expr(ast *Ast) {
+	if ast == nil {
+		// empty statement
+		return
+	}
+	switch ast.typ {
+	case AST_LITERAL:
+		switch ast.ctype.typ {
+		case CTYPE_CHAR, CTYPE_SHORT, CTYPE_INT, CTYPE_LONG, CTYPE_LLONG:
+			emit("mov $%d, %%rax", ast.ival)
+		case CTYPE_FLOAT:
+			emit("movss %s(%%rip), %%xmm0", ast.flabel)
+		case CTYPE_DOUBLE:
+			emit("movsd %s(%%rip), %%xmm0", ast.flabel)
+		case CTYPE_LDOUBLE:
+			emit("fldt %s(%%rip)", ast.flabel)
+		default:
+			errorf("internal error")
+		}
+	case AST_STRING:
+		emit("lea %s(%%rip), %%rax", ast.slabel)
+	case AST_LVAR:
+		emit_lload(ast.ctype, ast.loff)
+	case AST_GVAR:
+		emit_gload(ast.ctype, ast.glabel, 0)
+	case AST_FUNCALL, AST_FUNCPTR_CALL:
+		emit_func_call(ast)
+	case AST_FUNCDESG:
+		emit_addr(ast)
 	case AST_DECL:
 		if ast.declinit == nil {
 			return
 		}
 		if ast.declinit.typ == AST_INIT_LIST {
-			off := 0
+			emit_fill_zero(ast.declvar.loff, ast.declvar.ctype.size)
 			for _, v := range ast.declinit.initlist {
-				elem := v
-				emit_expr(elem)
-				emit_lsave(elem.totype, ast.declvar.loff+off)
-				off += elem.totype.size
-			}
-		} else if ast.declvar.ctype.typ == CTYPE_ARRAY {
-			assert(ast.declinit.typ == AST_STRING)
-			var i int
-			for i, char := range ast.declinit.val {
-				emit("movb $%d, %d(%%rbp)", char, ast.declvar.loff+i)
+				emit_init_elem(v, ast.declvar.loff+v.initoff)
 			}
-			emit("movb $0, %d(%%rbp)", ast.declvar.loff+i)
+		} else if ast.declvar.ctype.typ == CTYPE_STRUCT {
+			emit_expr(ast.declinit)
+			emit("mov %%rax, %%rcx")
+			emit("lea %d(%%rbp), %%rax", ast.declvar.loff)
+			emit_copy_struct(ast.declvar.ctype.size)
 		} else if ast.declinit.typ == AST_STRING {
 			emit_gload(ast.declinit.ctype, ast.declinit.slabel, 0)
 			emit_lsave(ast.declvar.ctype, ast.declvar.loff)
 		} else {
 			emit_expr(ast.declinit)
+			emit_conv(ast.declinit.ctype, ast.declvar.ctype)
 			emit_lsave(ast.declvar.ctype, ast.declvar.loff)
+			emit_discard(ast.declvar.ctype)
 		}
 	case AST_ADDR:
-		switch ast.operand.typ {
-		case AST_LVAR:
-			emit("lea %d(%%rbp), %%rax", ast.operand.loff)
-		case AST_GVAR:
-			emit("lea %s(%%rip), %%rax", ast.operand.glabel)
-		default:
-			errorf("internal error")
-		}
+		emit_addr(ast.operand)
 	case AST_DEREF:
 		emit_expr(ast.operand)
-		emit_load_deref(ast.ctype, ast.operand.ctype, 0)
+		emit_load_deref(ast.ctype, 0)
 	case AST_IF, AST_TERNARY:
-		emit_expr(ast.cond)
+		emit_cond(ast.cond)
 		ne := make_label()
-		emit("test %%rax, %%rax")
+		emit("test %%eax, %%eax")
 		emit("je %s", ne)
 		emit_expr(ast.then)
 		if ast.els != nil {
@@ -560,23 +1386,75 @@ func emit_expr(ast *Ast) {
 			emit_expr(ast.init)
 		}
 		begin := make_label()
+		step := make_label()
 		end := make_label()
 		emit("%s:", begin)
 		if ast.cond != nil {
-			emit_expr(ast.cond)
-			emit("test %%rax, %%rax")
+			emit_cond(ast.cond)
+			emit("test %%eax, %%eax")
 			emit("je %s", end)
 		}
+		push_loop(step, end)
 		emit_expr(ast.body)
+		pop_loop()
+		emit("%s:", step)
 		if ast.step != nil {
 			emit_expr(ast.step)
 		}
 		emit("jmp %s", begin)
 		emit("%s:", end)
+	case AST_WHILE:
+		begin := make_label()
+		end := make_label()
+		emit("%s:", begin)
+		emit_cond(ast.cond)
+		emit("test %%eax, %%eax")
+		emit("je %s", end)
+		push_loop(begin, end)
+		emit_expr(ast.body)
+		pop_loop()
+		emit("jmp %s", begin)
+		emit("%s:", end)
+	case AST_DO:
+		begin := make_label()
+		cond := make_label()
+		end := make_label()
+		emit("%s:", begin)
+		push_loop(cond, end)
+		emit_expr(ast.body)
+		pop_loop()
+		emit("%s:", cond)
+		emit_cond(ast.cond)
+		emit("test %%eax, %%eax")
+		emit("jne %s", begin)
+		emit("%s:", end)
+	case AST_BREAK:
+		loop := current_loop()
+		if loop == nil {
+			errorf("stray break statement")
+		}
+		emit("jmp %s", loop.lbreak)
+	case AST_CONTINUE:
+		loop := current_loop()
+		if loop == nil || loop.lcontinue == "" {
+			errorf("stray continue statement")
+		}
+		emit("jmp %s", loop.lcontinue)
+	case AST_SWITCH:
+		emit_switch(ast)
+	case AST_CASE, AST_DEFAULT, AST_LABEL:
+		emit("%s:", ast.label)
+		emit_expr(ast.body)
+	case AST_GOTO:
+		emit("jmp %s", ast.label)
 	case AST_RETURN:
 		if ast.retval != nil {
 			emit_expr(ast.retval)
-			emit_save_convert(ast.ctype, ast.retval.ctype)
+			if ast.ctype.typ == CTYPE_STRUCT {
+				emit_return_struct(ast.ctype)
+			} else {
+				emit_conv(ast.retval.ctype, ast.ctype)
+			}
 		}
 		emit("leave")
 		emit("ret")
@@ -587,66 +1465,117 @@ func emit_expr(ast *Ast) {
 		}
 	case AST_STRUCT_REF:
 		emit_load_struct_ref(ast.struc, ast.ctype, 0)
-	case OP_INC:
-		emit_inc_dec(ast, "add")
-	case OP_DEC:
-		emit_inc_dec(ast, "sub")
+		if ast.ctype.bitsize > 0 {
+			emit_bitfield_extract(ast.ctype)
+		}
+	case OP_A_ADD, OP_A_SUB, OP_A_MUL, OP_A_DIV, OP_A_MOD,
+		OP_A_AND, OP_A_OR, OP_A_XOR, OP_A_SAL, OP_A_SAR,
+		OP_PRE_INC, OP_PRE_DEC:
+		emit_assign_op(ast)
+	case OP_POST_INC, OP_POST_DEC:
+		emit_post_inc_dec(ast)
 	case '!':
+		emit_cond(ast.operand)
+		emit("xor $1, %%eax")
+	case '~':
 		emit_expr(ast.operand)
-		emit("cmp $0, %%rax")
-		emit("sete %%al")
-		emit("movzb %%al, %%eax")
-	case '&':
-		emit_expr(ast.left)
-		push("rax")
-		emit_expr(ast.right)
-		pop("rcx")
-		emit("and %%rcx, %%rax")
-	case '|':
-		emit_expr(ast.left)
-		push("rax")
-		emit_expr(ast.right)
-		pop("rcx")
-		emit("or %%rcx, %%rax")
+		emit("not %%rax")
+		emit_intcast(ast.ctype)
+	case OP_CAST:
+		emit_cast(ast)
+	case AST_VA_START:
+		emit_expr(ast.operand)
+		emit_va_start()
+	case AST_VA_ARG:
+		emit_va_arg(ast)
 	case OP_LOGAND:
 		end := make_label()
-		emit_expr(ast.left)
-		emit("test %%rax, %%rax")
-		emit("mov $0, %%rax")
+		emit_cond(ast.left)
+		emit("test %%eax, %%eax")
 		emit("je %s", end)
-		emit_expr(ast.right)
-		emit("test %%rax, %%rax")
-		emit("mov $0, %%rax")
-		emit("je %s", end)
-		emit("mov $1, %%rax")
+		emit_cond(ast.right)
 		emit("%s:", end)
 	case OP_LOGOR:
 		end := make_label()
-		emit_expr(ast.left)
-		emit("test %%rax, %%rax")
-		emit("mov $1, %%rax")
-		emit("jne %s", end)
-		emit_expr(ast.right)
-		emit("test %%rax, %%rax")
-		emit("mov $1, %%rax")
+		emit_cond(ast.left)
+		emit("test %%eax, %%eax")
 		emit("jne %s", end)
-		emit("mov $0, %%rax")
+		emit_cond(ast.right)
 		emit("%s:", end)
 	default:
 		emit_binop(ast)
 	}
 }
 
+// emit_fill_zero zeroes size bytes at off(%rbp).
+func emit_fill_zero(off int, size int) {
+	for ; size >= 8; size, off = size-8, off+8 {
+		emit("movq $0, %d(%%rbp)", off)
+	}
+	for ; size >= 4; size, off = size-4, off+4 {
+		emit("movl $0, %d(%%rbp)", off)
+	}
+	for ; size > 0; size, off = size-1, off+1 {
+		emit("movb $0, %d(%%rbp)", off)
+	}
+}
+
+// emit_init_elem stores the value of an element of an initializer list
+// to off(%rbp).
+func emit_init_elem(v *Ast, off int) {
+	emit_expr(v.operand)
+	switch {
+	case v.totype.typ == CTYPE_STRUCT:
+		emit("mov %%rax, %%rcx")
+		emit("lea %d(%%rbp), %%rax", off)
+		emit_copy_struct(v.totype.size)
+	case v.totype.bitsize > 0:
+		emit_conv(v.operand.ctype, v.totype)
+		emit_bitfield_position(v.totype)
+		push("rax")
+		emit("lea %d(%%rbp), %%rax", off)
+		emit_bitfield_store(v.totype)
+	default:
+		emit_conv(v.operand.ctype, v.totype)
+		emit_lsave(v.totype, off)
+		emit_discard(v.totype)
+	}
+}
+
 func emit_data_section() {
 	emit(".data")
 	for _, v := range gstrings {
+		if !is_wide_enc(v.enc) {
+			emit_label("%s:", v.slabel)
+			emit(".string \"%s\"", quote_chars(v.chars, v.enc))
+			continue
+		}
+		ctype := enc_type(v.enc)
+		emit(".align %d", ctype.size)
 		emit_label("%s:", v.slabel)
-		emit(".string \"%s\"", quote_cstring(v.val))
+		directive := ".long"
+		if ctype.size == 2 {
+			directive = ".short"
+		}
+		for _, c := range v.chars {
+			emit("%s %d", directive, c)
+		}
+		emit("%s 0", directive)
 	}
 	for _, v := range flonums {
 		label := make_label()
 		v.flabel = label
 		emit_label("%s:", label)
+		if v.ctype.typ == CTYPE_FLOAT {
+			emit(".long %d", math.Float32bits(float32(v.fval)))
+			continue
+		}
+		if v.ctype.typ == CTYPE_LDOUBLE {
+			mant, exp := ldouble_bits(v.lval)
+			emit(".quad %d", mant)
+			emit(".quad %d", exp)
+			continue
+		}
 
 		up1 := unsafe.Pointer(&v.fval)
 		up2 := unsafe.Pointer(uintptr(up1) + 4) // 4 means the size of int32
@@ -657,6 +1586,37 @@ func emit_data_section() {
 	}
 }
 
+// ldouble_bits returns the 64-bit mantissa, whose integer bit is
+// explicit, and the sign and 15-bit biased exponent of the 80-bit
+// extended precision format.
+func ldouble_bits(f *big.Float) (uint64, uint16) {
+	var sign uint16
+	if f.Signbit() {
+		sign = 0x8000
+	}
+	if f.IsInf() {
+		return 1 << 63, sign | 0x7fff
+	}
+	if f.Sign() == 0 {
+		return 0, sign
+	}
+	mant := new(big.Float)
+	exp := f.MantExp(mant)
+	mant.Abs(mant)
+	// mant is in [0.5, 1).
+	biased := exp - 1 + 16383
+	if biased >= 0x7fff {
+		return 1 << 63, sign | 0x7fff
+	}
+	if biased <= 0 {
+		// A denormal, of which the exponent is taken as 1
+		m, _ := mant.SetMantExp(mant, 64+biased-1).Uint64()
+		return m, sign
+	}
+	m, _ := mant.SetMantExp(mant, 64).Uint64()
+	return m, sign | uint16(biased)
+}
+
 func align(n int, m int) int {
 	rem := n % m
 	if rem == 0 {
@@ -666,35 +1626,104 @@ func align(n int, m int) int {
 	}
 }
 
-func emit_data_int(data *Ast) {
-	assert(data.ctype.typ != CTYPE_ARRAY)
-	switch data.ctype.size {
-	case 1:
-		emit(".byte %d", data.ival)
-	case 4:
-		emit(".long %d", data.ival)
-	case 8:
-		emit(".quad %d", data.ival)
-	default:
-		errorf("internal error")
+// emit_data_list emits the initializer of a global variable. The values
+// are written to an image of the variable first, as the unit of a
+// bit-field may overlap other members. Addresses are emitted as
+// relocations at their offsets.
+func emit_data_list(inits []*Ast, size int) {
+	buf := make([]byte, size)
+	relocs := make(map[int]string)
+	for _, v := range inits {
+		var val int
+		switch {
+		case v.totype.typ == CTYPE_PTR:
+			label, off := eval_static_addr(v.operand)
+			if label != "" {
+				relocs[v.initoff] = format("%s%+d", label, off)
+				continue
+			}
+			val = off
+		case v.totype.typ == CTYPE_LDOUBLE:
+			mant, exp := ldouble_bits(v.operand.lval)
+			for i := 0; i < 8; i++ {
+				buf[v.initoff+i] = byte(mant >> uint(i*8))
+			}
+			buf[v.initoff+8] = byte(exp)
+			buf[v.initoff+9] = byte(exp >> 8)
+			continue
+		case v.totype.typ == CTYPE_FLOAT:
+			val = int(math.Float32bits(float32(v.operand.fval)))
+		case is_flotype(v.totype):
+			val = int(math.Float64bits(v.operand.fval))
+		default:
+			val = v.operand.ival
+		}
+		n := v.totype.size
+		if v.totype.bitsize > 0 {
+			unit := 0
+			for i := n - 1; i >= 0; i-- {
+				unit = unit<<8 | int(buf[v.initoff+i])
+			}
+			mask := (1<<uint(v.totype.bitsize) - 1) << uint(v.totype.bitoff)
+			val = unit&^mask | val<<uint(v.totype.bitoff)&mask
+		}
+		for i := 0; i < n; i++ {
+			buf[v.initoff+i] = byte(val >> uint(i*8))
+		}
+	}
+	for i := 0; i < size; {
+		if reloc, ok := relocs[i]; ok {
+			emit(".quad %s", reloc)
+			i += 8
+			continue
+		}
+		j := i
+		for j < size && buf[j] == 0 && relocs[j] == "" {
+			j++
+		}
+		if j > i {
+			emit(".zero %d", j-i)
+			i = j
+			continue
+		}
+		for j < size && buf[j] != 0 && relocs[j] == "" {
+			j++
+		}
+		s := format("%d", buf[i])
+		for k := i + 1; k < j; k++ {
+			s += format(", %d", buf[k])
+		}
+		emit(".byte %s", s)
+		i = j
 	}
 }
 
 func emit_data(v *Ast) {
-	emit_label(".global %s", v.declvar.varname)
-	emit_label("%s:", v.declvar.varname)
+	if is_readonly(v.declvar.ctype) {
+		emit(".section .rodata")
+	} else {
+		emit(".data")
+	}
+	if !v.declvar.isstatic {
+		emit_label(".global %s", v.declvar.glabel)
+	}
+	if v.declvar.ctype.align > 1 {
+		emit(".align %d", v.declvar.ctype.align)
+	}
+	emit_label("%s:", v.declvar.glabel)
 	if v.declinit.typ == AST_INIT_LIST {
-		for _, v := range v.declinit.initlist {
-			emit_data_int(v)
-		}
+		emit_data_list(v.declinit.initlist, v.declvar.ctype.size)
 		return
 	}
-	assert(v.declinit.typ == AST_LITERAL && is_inttype(v.declinit.ctype))
-	emit_data_int(v.declinit)
+	init := ast_init(v.declinit, v.declvar.ctype, 0)
+	emit_data_list([]*Ast{init}, v.declvar.ctype.size)
 }
 
 func emit_bss(v *Ast) {
-	emit(".lcomm %s, %d", v.declvar.varname, v.declvar.ctype.size)
+	if v.declvar.isstatic {
+		emit(".local %s", v.declvar.glabel)
+	}
+	emit(".comm %s, %d, %d", v.declvar.glabel, v.declvar.ctype.size, v.declvar.ctype.align)
 }
 
 func emit_global_var(v *Ast) {
@@ -707,39 +1736,153 @@ func emit_global_var(v *Ast) {
 
 func emit_func_prologue(fn *Ast) {
 	emit(".text")
-	emit_label(".global %s\n", fn.fname)
+	if !fn.isstatic {
+		emit_label(".global %s\n", fn.fname)
+	}
 	emit_label("%s:", fn.fname)
 	push("rbp")
 	emit("mov %%rsp, %%rbp")
 	off := 0
 	ireg := 0
-	xreg := 0
-	for _, v := range fn.params {
-		if is_flotype(v.ctype) {
-			push_xmm(xreg)
-			xreg++
-		} else if v.ctype.typ == CTYPE_DOUBLE || v.ctype.typ == CTYPE_LDOUBLE {
-			push_xmm(xreg)
-			xreg++
-		} else {
-			push(REGS[ireg])
-			ireg++
+	retmem := fn.ctype.rettype.typ == CTYPE_STRUCT && is_memory_class(fn.ctype.rettype)
+	if retmem {
+		ireg = 1
+		off -= 8
+		retbuf_off = off
+	}
+	locs, stacksize, gp, fp := assign_args(param_types(fn.params), ireg)
+	for i, v := range fn.params {
+		if locs[i].regs == nil {
+			// Above the saved %rbp and the return address.
+			v.loff = 16 + locs[i].stackoff
+			continue
 		}
-
 		off -= align(v.ctype.size, 8)
 		v.loff = off
 	}
-	localarea := 0
+	if fn.ctype.hasva {
+		off -= VA_REG_SAVE_AREA_SIZE
+		va_area_off = off
+		va_gp_offset = gp * 8
+		va_fp_offset = len(REGS)*8 + fp*16
+		va_stack_off = 16 + stacksize
+	}
 	for _, v := range fn.localvars {
-		a := align(v.ctype.size, 8)
-		off -= a
+		// %rbp is aligned to 16 bytes, which ast_lvar ensures is enough.
+		a := 8
+		if v.ctype.align > a {
+			a = v.ctype.align
+		}
+		off = -align(-off+v.ctype.size, a)
 		v.loff = off
-		localarea -= a
 	}
-	if localarea != 0 {
-		emit("sub $%d, %%rsp", -localarea)
+	if off != 0 {
+		size := align(-off, 16)
+		emit("sub $%d, %%rsp", size)
+		stackpos += size
+	}
+	if retmem {
+		emit("mov %%rdi, %d(%%rbp)", retbuf_off)
+	}
+	for i, v := range fn.params {
+		for j, reg := range locs[i].regs {
+			emit_save_reg(reg, format("%d(%%rbp)", v.loff+j*8))
+		}
+	}
+	if fn.ctype.hasva {
+		for i, reg := range REGS {
+			emit("mov %%%s, %d(%%rbp)", reg, va_area_off+i*8)
+		}
+		for i := 0; i < NUM_XMM_REGS; i++ {
+			emit("movsd %%xmm%d, %d(%%rbp)", i, va_area_off+len(REGS)*8+i*16)
+		}
+	}
+}
+
+// emit_va_start initializes the va_list at (%rax) to point to the
+// first argument after the named parameters.
+func emit_va_start() {
+	emit("movl $%d, (%%rax)", va_gp_offset)
+	emit("movl $%d, 4(%%rax)", va_fp_offset)
+	emit("lea %d(%%rbp), %%rcx", va_stack_off)
+	emit("mov %%rcx, 8(%%rax)")
+	emit("lea %d(%%rbp), %%rcx", va_area_off)
+	emit("mov %%rcx, 16(%%rax)")
+}
+
+// emit_va_next_reg sets %rax to the address of the next general purpose
+// or SSE register in the register save area of the va_list at (%rcx),
+// and advances the offset of the va_list.
+func emit_va_next_reg(class int) {
+	off := 0
+	step := 8
+	if class == CLASS_SSE {
+		off = 4
+		step = 16
+	}
+	emit("mov %d(%%rcx), %%eax", off)
+	emit("lea %d(%%rax), %%edx", step)
+	emit("mov %%edx, %d(%%rcx)", off)
+	emit("add 16(%%rcx), %%rax")
+}
+
+// emit_va_arg reads the next argument from a va_list. It is taken from
+// the register save area if all its eightbytes are left there, and
+// from the overflow area on the stack otherwise.
+func emit_va_arg(ast *Ast) {
+	ctype := ast.ctype
+	emit_expr(ast.operand)
+	emit("mov %%rax, %%rcx")
+	classes := classify(ctype)
+	stack := make_label()
+	end := make_label()
+	if classes[0] != CLASS_MEMORY {
+		nint := 0
+		nsse := 0
+		for _, c := range classes {
+			if c == CLASS_INTEGER {
+				nint++
+			} else {
+				nsse++
+			}
+		}
+		if nint > 0 {
+			emit("cmpl $%d, (%%rcx)", len(REGS)*8-nint*8)
+			emit("ja %s", stack)
+		}
+		if nsse > 0 {
+			emit("cmpl $%d, 4(%%rcx)", VA_REG_SAVE_AREA_SIZE-nsse*16)
+			emit("ja %s", stack)
+		}
+		if ctype.typ == CTYPE_STRUCT {
+			// The eightbytes may not be next to each other.
+			for i, c := range classes {
+				emit_va_next_reg(c)
+				emit("mov (%%rax), %%r11")
+				emit("mov %%r11, %d(%%rbp)", ast.retbuf.loff+i*8)
+			}
+			emit("lea %d(%%rbp), %%rax", ast.retbuf.loff)
+		} else {
+			emit_va_next_reg(classes[0])
+		}
+		emit("jmp %s", end)
+	}
+	emit("%s:", stack)
+	emit("mov 8(%%rcx), %%rax")
+	if ctype.align > 8 {
+		emit("add $15, %%rax")
+		emit("and $-16, %%rax")
+	}
+	emit("lea %d(%%rax), %%rdx", align(ctype.size, 8))
+	emit("mov %%rdx, 8(%%rcx)")
+	emit("%s:", end)
+	if ctype.typ == CTYPE_FLOAT {
+		// A float argument has been promoted to double.
+		emit_load_deref(ctype_double, 0)
+		emit_conv(ctype_double, ctype)
+	} else {
+		emit_load_deref(ctype, 0)
 	}
-	stackpos += -(off - 8)
 }
 
 func emit_func_epilogue() {
@@ -750,6 +1893,8 @@ func emit_func_epilogue() {
 func emit_toplevel(v *Ast) {
 	stackpos = 0
 	if v.typ == AST_FUNC {
+		// The return address has been pushed.
+		stackpos = 8
 		emit_func_prologue(v)
 		emit_expr(v.body)
 		emit_func_epilogue()
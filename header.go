@@ -1,5 +1,7 @@
 package main
 
+import "math/big"
+
 type float float32
 
 const FLOAT_SIZE = 32
@@ -15,6 +17,15 @@ const (
 	TTYPE_MACRO_PARAM
 )
 
+// Encodings of string and character literals, given by their prefixes
+const (
+	ENC_NONE   = iota
+	ENC_CHAR16 // u
+	ENC_CHAR32 // U
+	ENC_UTF8   // u8
+	ENC_WCHAR  // L
+)
+
 type Token struct {
 	typ     int
 	space   bool
@@ -26,7 +37,9 @@ type Token struct {
 	// intends union
 	sval     string
 	punct    int
-	c        byte
+	c        int
+	enc      int
+	chars    []int // the code units of a string or character literal
 	position int
 }
 
@@ -36,17 +49,31 @@ const (
 	AST_LVAR
 	AST_GVAR
 	AST_FUNCALL
+	AST_FUNCPTR_CALL
+	AST_FUNCDESG
 	AST_FUNC
 	AST_DECL
 	AST_INIT_LIST
+	AST_INIT
 	AST_ADDR
 	AST_DEREF
 	AST_IF
 	AST_TERNARY
 	AST_FOR
+	AST_WHILE
+	AST_DO
+	AST_BREAK
+	AST_CONTINUE
+	AST_SWITCH
+	AST_CASE
+	AST_DEFAULT
+	AST_GOTO
+	AST_LABEL
 	AST_RETURN
 	AST_COMPOUND_STMT
 	AST_STRUCT_REF
+	AST_VA_START
+	AST_VA_ARG
 	OP_EQ
 	OP_NE
 	OP_LE
@@ -56,6 +83,25 @@ const (
 	OP_LOGAND
 	OP_LOGOR
 	OP_ARROW
+	OP_PRE_INC
+	OP_PRE_DEC
+	OP_POST_INC
+	OP_POST_DEC
+	OP_CAST
+	OP_SAL
+	OP_SAR
+	OP_A_ADD
+	OP_A_SUB
+	OP_A_MUL
+	OP_A_DIV
+	OP_A_MOD
+	OP_A_AND
+	OP_A_OR
+	OP_A_XOR
+	OP_A_SAL
+	OP_A_SAR
+	OP_ELLIPSIS
+	OP_HASHHASH
 )
 
 const (
@@ -79,8 +125,12 @@ const (
 type Ctype struct {
 	typ  int
 	size int
+	// alignment in bytes
+	align int
 	// true if signed
 	sig bool
+	// const-qualified
+	isconst bool
 	// pointer or array
 	ptr  *Ctype
 	// array length
@@ -88,10 +138,16 @@ type Ctype struct {
 	// struct
 	fields *Dict
 	offset int
+	isunion bool
+	// bit-field; bitsize is -1 for the other struct members
+	bitoff  int
+	bitsize int
 	// function
 	rettype *Ctype
 	params  []*Ctype
 	hasva bool
+	// declared with () that does not specify the parameters
+	oldstyle bool
 }
 
 type Ast struct {
@@ -103,15 +159,20 @@ type Ast struct {
 	// Float or double
 	fval   float64
 	flabel string
+	// Long double, in 64 bits of precision
+	lval *big.Float
 
 	// pseudo Union
-	// String
-	val    string
+	// String. The code units in the encoding, without the terminating NUL.
+	chars  []int
+	enc    int
 	slabel string
 	// Local/Global variable
 	varname string
 	loff    int
 	glabel  string
+	// Global variable or function not visible from other files
+	isstatic bool
 	// Binary operator
 	left  *Ast
 	right *Ast
@@ -122,6 +183,9 @@ type Ast struct {
 	// Function call
 	args       []*Ast
 	paramtypes []*Ctype
+	retbuf     *Ast // temporary for a struct returned or read by va_arg
+	// Function pointer call
+	fptr *Ast
 	// Function declaration
 	params    []*Ast
 	localvars []*Ast
@@ -131,16 +195,24 @@ type Ast struct {
 	declinit *Ast
 	// array or struct initializer
 	initlist []*Ast
-	totype *Ctype
+	// element of an initializer list
+	totype  *Ctype
+	initoff int
 	// If statement or ternary operator
 	cond *Ast
 	then *Ast
 	els  *Ast
-	// For statement
+	// For, while or do statement
 	init *Ast
 	//	cond *Ast
 	step *Ast
 	//	body *Ast
+	// Switch statement
+	cases   []*Ast
+	defcase *Ast
+	// Case, default or goto label
+	label     string
+	labelname string
 	// return
 	retval *Ast
 	// compound
@@ -3,10 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
-const BUFLEN = 256
-
 var at_bol = true
 
 type File struct {
@@ -56,6 +56,8 @@ func make_ident(p string) *Token {
 	return r
 }
 
+// make_strtok makes a string literal, which is written as s between
+// the quotes.
 func make_strtok(s string) *Token {
 	r := make_token(TTYPE_STRING)
 	r.sval = s
@@ -65,6 +67,7 @@ func make_strtok(s string) *Token {
 func make_punct(punct int) *Token {
 	r := make_token(TTYPE_PUNCT)
 	r.punct = punct
+	r.sval = punct_spelling(punct)
 	return r
 }
 
@@ -74,15 +77,24 @@ func make_number(s string) *Token {
 	return r
 }
 
-func make_char(c byte) *Token {
+// make_char makes a character constant of value c, which is written
+// as s between the quotes.
+func make_char(c int, s string) *Token {
 	r := make_token(TTYPE_CHAR)
 	r.c = c
+	r.sval = s
 	return r
 }
 
 func push_input_file(filename string, input *os.File) {
+	push_input_stream(filename, newStream(input))
+}
+
+// push_input_stream reads from fp until its end, and then returns to
+// the current input.
+func push_input_stream(filename string, fp *stream) {
 	file_stack = append(file_stack, file)
-	file = make_file(filename, newStream(input))
+	file = make_file(filename, fp)
 	at_bol = true
 }
 
@@ -185,6 +197,15 @@ func skip_cond_incl() {
 		if c == '\n' {
 			continue
 		}
+		if c == '%' {
+			// %: is a digraph of #.
+			c2, err := get()
+			if err == nil && c2 == ':' {
+				c = '#'
+			} else if err == nil {
+				unget(c2)
+			}
+		}
 		if c != '#' {
 			skip_line()
 			continue
@@ -214,12 +235,19 @@ func skip_cond_incl() {
 	}
 }
 
+// read_number reads a preprocessing number. It is a digit or a period
+// followed by digits, letters, underscores, periods and the signs of
+// exponents.
 func read_number(c byte) *Token {
-	var b []byte
-	b = append(b, c)
+	b := []byte{c}
 	for {
-		c, _ := get()
-		if !isdigit(c) && !isalpha(c) && c != '.' {
+		c, err := get()
+		if err != nil {
+			return make_number(string(b))
+		}
+		last := b[len(b)-1]
+		sign := (c == '+' || c == '-') && (last == 'e' || last == 'E' || last == 'p' || last == 'P')
+		if !isdigit(c) && !isalpha(c) && c != '_' && c != '.' && !sign {
 			unget(c)
 			return make_number(string(b))
 		}
@@ -227,67 +255,289 @@ func read_number(c byte) *Token {
 	}
 }
 
-func read_char() *Token {
-	c, err := get()
-	if err != nil {
-		errorf("Unterminated char")
+// read_octal_char reads an octal escape sequence at s[i], and returns
+// its value and the index after it.
+func read_octal_char(s string, i int) (int, int) {
+	r := 0
+	for n := 0; n < 3 && i < len(s) && '0' <= s[i] && s[i] <= '7'; n++ {
+		r = r<<3 | int(s[i]-'0')
+		i++
 	}
-	if c == '\\' {
-		c, err = get()
-		if err != nil {
-			errorf("Unterminated char")
+	return r, i
+}
+
+func read_hex_char(s string, i int) (int, int) {
+	if i == len(s) || !isxdigit(s[i]) {
+		errorf("\\x is not followed by a hexadecimal character")
+	}
+	r := 0
+	for ; i < len(s) && isxdigit(s[i]); i++ {
+		r = r<<4 | hexval(s[i])
+		if r > 0xffffffff {
+			errorf("Hex escape sequence out of range")
 		}
 	}
+	return r, i
+}
 
-	c2, err := get()
-	if err != nil {
-		errorf("Unterminated char")
+// read_universal_char reads a universal character name of n hex digits
+// after \\u or \\U.
+func read_universal_char(s string, i int, n int) (int, int) {
+	r := 0
+	for j := 0; j < n; j++ {
+		if i == len(s) || !isxdigit(s[i]) {
+			errorf("Incomplete universal character name")
+		}
+		r = r<<4 | hexval(s[i])
+		i++
+	}
+	if r < 0xa0 && r != '$' && r != '@' && r != '`' || 0xd800 <= r && r <= 0xdfff || r > 0x10ffff {
+		errorf("Invalid universal character: \\U%08x", r)
+	}
+	return r, i
+}
+
+func hexval(c byte) int {
+	switch {
+	case c <= '9':
+		return int(c - '0')
+	case c <= 'F':
+		return int(c - 'A' + 10)
+	default:
+		return int(c - 'a' + 10)
+	}
+}
+
+// read_escaped_char reads an escape sequence after the backslash at
+// s[i-1]. It returns the value, whether it is a universal character
+// name, and the index after it.
+func read_escaped_char(s string, i int) (int, bool, int) {
+	if i == len(s) {
+		errorf("Unterminated \\")
+	}
+	c := s[i]
+	i++
+	switch c {
+	case '\'', '"', '?', '\\':
+		return int(c), false, i
+	case 'a':
+		return '\a', false, i
+	case 'b':
+		return '\b', false, i
+	case 'f':
+		return '\f', false, i
+	case 'n':
+		return '\n', false, i
+	case 'r':
+		return '\r', false, i
+	case 't':
+		return '\t', false, i
+	case 'v':
+		return '\v', false, i
+	case 'x':
+		r, i := read_hex_char(s, i)
+		return r, false, i
+	case 'u':
+		r, i := read_universal_char(s, i, 4)
+		return r, true, i
+	case 'U':
+		r, i := read_universal_char(s, i, 8)
+		return r, true, i
 	}
-	if c2 != '\'' {
-		errorf("Malformed char constant")
+	if c < '0' || '7' < c {
+		errorf("Unknown escape character: \\%c", c)
 	}
+	r, i := read_octal_char(s, i-1)
+	return r, false, i
+}
+
+// is_wide_enc returns true if the characters of the encoding are
+// wider than a byte.
+func is_wide_enc(enc int) bool {
+	return enc == ENC_CHAR16 || enc == ENC_CHAR32 || enc == ENC_WCHAR
+}
 
-	return make_char(c)
+// max_char returns the largest code unit of the encoding.
+func max_char(enc int) int {
+	return 1<<(uint(enc_type(enc).size)*8) - 1
 }
 
-func read_string() *Token {
-	buf := make([]byte, 0, BUFLEN)
+// append_unicode appends the code units of the Unicode character c
+// in the encoding to chars.
+func append_unicode(chars []int, c int, enc int) []int {
+	switch enc {
+	case ENC_CHAR16:
+		if c > 0xffff {
+			r1, r2 := utf16.EncodeRune(rune(c))
+			return append(chars, int(r1), int(r2))
+		}
+		return append(chars, c)
+	case ENC_CHAR32, ENC_WCHAR:
+		return append(chars, c)
+	}
+	for _, b := range []byte(string(rune(c))) {
+		chars = append(chars, int(b))
+	}
+	return chars
+}
+
+// decode_literal returns the code units of the body of a string or
+// character literal, as written between the quotes, in the encoding.
+// Those of a wide literal are in UTF-16 or UTF-32, and the others in
+// UTF-8. An escape sequence other than a universal character name is
+// a single code unit, which must fit in the character type.
+func decode_literal(s string, enc int) []int {
+	r := []int{}
+	for i := 0; i < len(s); {
+		if s[i] == '\\' {
+			c, ucn, next := read_escaped_char(s, i+1)
+			i = next
+			if ucn {
+				r = append_unicode(r, c, enc)
+				continue
+			}
+			if c > max_char(enc) {
+				errorf("Escape sequence out of range")
+			}
+			r = append(r, c)
+			continue
+		}
+		if !is_wide_enc(enc) {
+			r = append(r, int(s[i]))
+			i++
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			// Not UTF-8; the byte is taken as it is.
+			c = rune(s[i])
+		}
+		r = append_unicode(r, int(c), enc)
+		i += size
+	}
+	return r
+}
+
+// read_literal reads the body of a string or character literal up to
+// the closing quote q, and returns it as written.
+func read_literal(q byte) string {
+	var buf []byte
 	for {
 		c, err := get()
-		if err != nil {
-			errorf("Unterminated string")
+		if err != nil || c == '\n' {
+			if q == '"' {
+				errorf("Unterminated string")
+			}
+			errorf("Unterminated char")
 		}
-		if c == '"' {
-			break
+		if c == q {
+			return string(buf)
 		}
+		buf = append(buf, c)
 		if c == '\\' {
 			c, err = get()
-			if err != nil {
+			if err != nil || c == '\n' {
 				errorf("Unterminated \\")
 			}
-			switch c {
-			case '"':
-				break
-			case '\\':
-				c = '\\'
-				break
-			case 'n':
-				c = '\n'
-			default:
-				errorf("Unknown quote: %c", c)
+			buf = append(buf, c)
+		}
+	}
+}
+
+// read_char reads a character constant. The value of a multi-character
+// constant has the first character in its most significant byte, as in
+// gcc.
+func read_char(enc int) *Token {
+	s := read_literal('\'')
+	chars := decode_literal(s, enc)
+	if len(chars) == 0 {
+		errorf("Empty character constant")
+	}
+	r := make_char(0, s)
+	r.enc = enc
+	r.chars = chars
+	if is_wide_enc(enc) {
+		if len(chars) > 1 {
+			if enc == ENC_CHAR16 && len(chars) == 2 && utf16.IsSurrogate(rune(chars[0])) {
+				errorf("Character too large for its type: u'%s'", s)
 			}
+			errorf("Multi-character wide character constant: %s'%s'", enc_prefix(enc), s)
 		}
-		buf = append(buf, c)
-		if len(buf) == BUFLEN-1 {
-			errorf("String too long")
+		r.c = chars[0]
+		if enc == ENC_WCHAR {
+			// wchar_t is signed.
+			r.c = int(int32(r.c))
 		}
+		return r
+	}
+	if len(chars) == 1 {
+		// char is signed.
+		r.c = int(int8(chars[0]))
+		return r
+	}
+	if len(chars) > 4 {
+		errorf("Character constant too long: '%s'", s)
+	}
+	for _, c := range chars {
+		r.c = r.c<<8 | c
 	}
-	return make_strtok(string(buf))
+	r.c = int(int32(r.c))
+	return r
+}
+
+// read_string reads a string literal. Its characters are decoded when
+// adjacent literals are concatenated, as the encoding of the result
+// is not known yet.
+func read_string(enc int) *Token {
+	r := make_strtok(read_literal('"'))
+	r.enc = enc
+	return r
+}
+
+// read_encoded_literal reads a string or character literal with the
+// encoding prefix starting with c, or returns nil if there is none.
+func read_encoded_literal(c byte) *Token {
+	var enc int
+	switch c {
+	case 'L':
+		enc = ENC_WCHAR
+	case 'U':
+		enc = ENC_CHAR32
+	case 'u':
+		enc = ENC_CHAR16
+		c2, err := get()
+		if err != nil {
+			return nil
+		}
+		if c2 == '8' {
+			c3, err := get()
+			if err == nil && c3 == '"' {
+				return read_string(ENC_UTF8)
+			}
+			if err == nil {
+				unget(c3)
+			}
+		}
+		unget(c2)
+	default:
+		return nil
+	}
+	c2, err := get()
+	if err != nil {
+		return nil
+	}
+	switch c2 {
+	case '"':
+		return read_string(enc)
+	case '\'':
+		return read_char(enc)
+	}
+	unget(c2)
+	return nil
 }
 
 func read_ident(c byte) *Token {
-	buf := make([]byte, 0, BUFLEN)
-	buf = append(buf, c)
+	buf := []byte{c}
 	for {
 		c2, _ := get()
 		if isalnum(c2) || c2 == '_' {
@@ -320,13 +570,81 @@ func skip_block_comment() {
 	}
 }
 
-func read_rep(expect byte, t1 int, t2 int) *Token {
-	c, _ := get()
-	if c == expect {
-		return make_punct(t1)
+// punctuators are the spellings of the punctuators and their kinds.
+// The digraphs stand for the punctuators in their second columns.
+var punctuators = []struct {
+	s     string
+	punct int
+}{
+	{"[", '['}, {"]", ']'}, {"(", '('}, {")", ')'}, {"{", '{'}, {"}", '}'},
+	{".", '.'}, {"->", OP_ARROW}, {"++", OP_INC}, {"--", OP_DEC},
+	{"&", '&'}, {"*", '*'}, {"+", '+'}, {"-", '-'}, {"~", '~'}, {"!", '!'},
+	{"/", '/'}, {"%", '%'}, {"<<", OP_SAL}, {">>", OP_SAR},
+	{"<", '<'}, {">", '>'}, {"<=", OP_LE}, {">=", OP_GE},
+	{"==", OP_EQ}, {"!=", OP_NE}, {"^", '^'}, {"|", '|'},
+	{"&&", OP_LOGAND}, {"||", OP_LOGOR}, {"?", '?'}, {":", ':'}, {";", ';'},
+	{"...", OP_ELLIPSIS}, {"=", '='}, {"*=", OP_A_MUL}, {"/=", OP_A_DIV},
+	{"%=", OP_A_MOD}, {"+=", OP_A_ADD}, {"-=", OP_A_SUB}, {"<<=", OP_A_SAL},
+	{">>=", OP_A_SAR}, {"&=", OP_A_AND}, {"^=", OP_A_XOR}, {"|=", OP_A_OR},
+	{",", ','}, {"#", '#'}, {"##", OP_HASHHASH},
+	{"<:", '['}, {":>", ']'}, {"<%", '{'}, {"%>", '}'}, {"%:", '#'}, {"%:%:", OP_HASHHASH},
+}
+
+// The length of the longest punctuator
+const MAX_PUNCT_LEN = 4
+
+// punct_spelling returns the spelling of a punctuator, which is not a
+// digraph.
+func punct_spelling(punct int) string {
+	for _, p := range punctuators {
+		if p.punct == punct {
+			return p.s
+		}
+	}
+	errorf("internal error: unknown punctuator %d", punct)
+	return ""
+}
+
+// lookup_punct returns the kind of the punctuator spelled s.
+func lookup_punct(s string) (int, bool) {
+	for _, p := range punctuators {
+		if p.s == s {
+			return p.punct, true
+		}
 	}
-	unget(c)
-	return make_punct(t2)
+	return 0, false
+}
+
+// read_punct reads the longest punctuator starting with c, or returns
+// nil if there is none.
+func read_punct(c byte) *Token {
+	buf := []byte{c}
+	for len(buf) < MAX_PUNCT_LEN {
+		c, err := get()
+		if err != nil {
+			break
+		}
+		buf = append(buf, c)
+	}
+	n := len(buf)
+	for ; n > 0; n-- {
+		if _, ok := lookup_punct(string(buf[:n])); ok {
+			break
+		}
+	}
+	for i := len(buf) - 1; i >= n && i > 0; i-- {
+		unget(buf[i])
+	}
+	// The lookahead may have passed a newline; the next token is still
+	// on this line.
+	at_bol = false
+	if n == 0 {
+		return nil
+	}
+	punct, _ := lookup_punct(string(buf[:n]))
+	r := make_punct(punct)
+	r.sval = string(buf[:n])
+	return r
 }
 
 func read_token_int() *Token {
@@ -345,68 +663,40 @@ func read_token_int() *Token {
 	case '0' <= c && c <= '9':
 		return read_number(c)
 	case ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_':
+		if tok := read_encoded_literal(c); tok != nil {
+			return tok
+		}
 		return read_ident(c)
 	case c == '/':
-		c, _ = get()
-		if c == '/' {
-			skip_line()
-			return space_token
-		}
-		if c == '*' {
-			skip_block_comment()
-			return space_token
+		c2, err := get()
+		if err == nil {
+			if c2 == '/' {
+				skip_line()
+				return space_token
+			}
+			if c2 == '*' {
+				skip_block_comment()
+				return space_token
+			}
+			unget(c2)
 		}
-		unget(c)
-		return make_punct('/')
 	case c == '.':
-		c, _ = get()
-		if c == '.' {
-			c, _ = get()
-			return make_ident(format("..%c", c))
-		}
-		unget(c)
-		return make_punct('.')
-
-	case c == '*' || c == '(' || c == ')' || c == ',' || c == ';' || c == '[' || c == ']' ||
-		c == '{' || c == '}' || c == '?' || c == ':':
-		return make_punct(int(c))
-	case c == '#':
-		c, _ = get()
-		if c == '#' {
-			return make_ident("##")
-		}
-		unget(c)
-		return make_punct('#')
-	case c == '-':
-		c, _ = get()
-		if c == '-' {
-			return make_punct(OP_DEC)
-		}
-		if c == '>' {
-			return make_punct(OP_ARROW)
+		c2, err := get()
+		if err == nil {
+			unget(c2)
+			if isdigit(c2) {
+				return read_number(c)
+			}
 		}
-		unget(c)
-		return make_punct('-')
-	case c == '<': return read_rep('=', OP_LE, '<')
-	case c == '>': return read_rep('=', OP_GE, '>')
-	case c == '=':
-		return read_rep('=', OP_EQ, int('='))
-	case c == '!':
-		return read_rep('=', OP_EQ, int('!'))
-	case c == '+':
-		return read_rep('+', OP_INC, int('+'))
-	case c == '&':
-		return read_rep('&', OP_LOGAND, int('&'))
-	case c == '|':
-		return read_rep('|', OP_LOGOR, int('|'))
 	case c == '"':
-		return read_string()
+		return read_string(ENC_NONE)
 	case c == '\'':
-		return read_char()
-	default:
-		errorf("Don't know how to handle '%c'", c)
+		return read_char(ENC_NONE)
 	}
-
+	if tok := read_punct(c); tok != nil {
+		return tok
+	}
+	errorf("Don't know how to handle '%c'", c)
 	return nil
 }
 
@@ -1,28 +1,302 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
-func main() {
-	initStdin()
+// Command line options
+var (
+	wantast  bool   // -a
+	cpponly  bool   // -E
+	dumpasm  bool   // -S
+	dontlink bool   // -c
+	outfile  string // -o
+	// -D, -U and -include as directives read before the input
+	cppdefs string
+	// The options given again to compile each input
+	cppflags []string
+	infiles  []string
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"Usage: 8ccg [ -E ][ -a ] [ -o output ] [ -S | -c ] [ -I dir ] [ -D name[=def] ]\n"+
+			"            [ -U name ] [ -include file ] [ -w ] [ file ... ]\n"+
+			"\n"+
+			"With no file, the standard input is compiled to the standard output.\n")
+	os.Exit(1)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "8ccg: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func parse_opts(args []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		// optarg returns the argument of the option, which is either
+		// joined to it or the next argument.
+		optarg := func(opt string) string {
+			if len(arg) > len(opt) {
+				return arg[len(opt):]
+			}
+			i++
+			if i == len(args) {
+				fatalf("missing argument to %s", opt)
+			}
+			return args[i]
+		}
+		switch {
+		case arg == "-a":
+			wantast = true
+		case arg == "-E":
+			cpponly = true
+		case arg == "-S":
+			dumpasm = true
+		case arg == "-c":
+			dontlink = true
+		case arg == "-w":
+			suppress_warning = true
+			cppflags = append(cppflags, arg)
+		case arg == "-include":
+			name := optarg("-include")
+			cppdefs += format("#include \"%s\"\n", name)
+			cppflags = append(cppflags, "-include", name)
+		case strings.HasPrefix(arg, "-o"):
+			outfile = optarg("-o")
+		case strings.HasPrefix(arg, "-I"):
+			dir := optarg("-I")
+			user_include_path = append(user_include_path, dir)
+			cppflags = append(cppflags, "-I"+dir)
+		case strings.HasPrefix(arg, "-D"):
+			def := optarg("-D")
+			name, val := def, "1"
+			if i := strings.IndexByte(def, '='); i >= 0 {
+				name, val = def[:i], def[i+1:]
+			}
+			cppdefs += format("#define %s %s\n", name, val)
+			cppflags = append(cppflags, "-D"+def)
+		case strings.HasPrefix(arg, "-U"):
+			name := optarg("-U")
+			cppdefs += format("#undef %s\n", name)
+			cppflags = append(cppflags, "-U"+name)
+		case len(arg) > 1 && arg[0] == '-':
+			fmt.Fprintf(os.Stderr, "8ccg: unknown option: %s\n", arg)
+			usage()
+		default:
+			infiles = append(infiles, arg)
+		}
+	}
+	if dumpasm && dontlink {
+		fatalf("-S and -c are exclusive")
+	}
+	if outfile != "" && len(infiles) > 1 && (cpponly || dumpasm || dontlink) {
+		fatalf("cannot specify -o with -E, -S or -c with multiple files")
+	}
+	if wantast && (len(infiles) > 1 || len(infiles) == 1 && filepath.Ext(infiles[0]) != ".c") {
+		fatalf("-a takes a single C file")
+	}
+}
+
+// compile compiles or preprocesses the input in fp to the output in out.
+func compile(name string, fp *stream, out *os.File) {
+	outfp = out
 	initLex()
 	initCpp()
-
-	wantast := len(os.Args) > 1 && os.Args[1] == "-a"
+	set_input_file(name, fp)
+	if cppdefs != "" {
+		push_input_stream("(command line)", newStringStream(cppdefs))
+	}
+	if cpponly {
+		preprocess()
+		return
+	}
 	toplevels := read_toplevels()
-
 	if !wantast {
 		emit_data_section()
 	}
-
 	for _, v := range toplevels {
 		if wantast {
-			printf("%s", v)
+			fmt.Fprintf(out, "%s", v)
 		} else {
 			emit_toplevel(v)
 		}
 	}
+}
+
+// compile_to compiles the input in fp to the file at path, or to the
+// standard output if path is empty.
+func compile_to(name string, fp *stream, path string) {
+	if path == "" {
+		compile(name, fp, os.Stdout)
+		return
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	defer func() {
+		out.Close()
+		// A partial output would be taken as up to date by make.
+		if r := recover(); r != nil {
+			os.Remove(path)
+			panic(r)
+		}
+	}()
+	compile(name, fp, out)
+}
+
+// compile_file compiles the file in this process. The output goes to
+// outfile if given, to the standard output with -E or -a, and otherwise
+// to the input file name with the suffix .s in the current directory.
+func compile_file(name string) {
+	fp, err := os.Open(name)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	path := outfile
+	if path == "" && !cpponly && !wantast {
+		path = replace_suffix(filepath.Base(name), ".s")
+	}
+	compile_to(name, newStream(fp), path)
+}
+
+func replace_suffix(name string, suffix string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + suffix
+}
+
+func run_command(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s", name, err)
+	}
+	return nil
+}
+
+// run_self runs this compiler on an input with the given options and
+// those for the preprocessor.
+func run_self(args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return run_command(exe, append(args, cppflags...)...)
+}
+
+func temp_file(suffix string) (string, error) {
+	f, err := os.CreateTemp("", "8ccg*"+suffix)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// drive compiles each C input in a process of its own, assembles the
+// results and links them unless told otherwise. Other inputs, such as
+// objects and libraries, are given to the linker as they are. The
+// temporary files are removed, and so is the output of a failed step.
+func drive() error {
+	var objs []string
+	var temps []string
+	defer func() {
+		for _, name := range temps {
+			os.Remove(name)
+		}
+	}()
+	for _, name := range infiles {
+		asm := name
+		switch filepath.Ext(name) {
+		case ".c":
+			if cpponly {
+				args := []string{"-E", name}
+				if outfile != "" {
+					args = []string{"-E", "-o", outfile, name}
+				}
+				if err := run_self(args...); err != nil {
+					return err
+				}
+				continue
+			}
+			if dumpasm {
+				asm := outfile
+				if asm == "" {
+					asm = replace_suffix(filepath.Base(name), ".s")
+				}
+				if err := run_self("-S", "-o", asm, name); err != nil {
+					return err
+				}
+				continue
+			}
+			var err error
+			if asm, err = temp_file(".s"); err != nil {
+				return err
+			}
+			temps = append(temps, asm)
+			if err := run_self("-S", "-o", asm, name); err != nil {
+				return err
+			}
+			fallthrough
+		case ".s":
+			if cpponly || dumpasm {
+				continue
+			}
+			obj := outfile
+			if !dontlink {
+				var err error
+				if obj, err = temp_file(".o"); err != nil {
+					return err
+				}
+				temps = append(temps, obj)
+			} else if obj == "" {
+				obj = replace_suffix(filepath.Base(name), ".o")
+			}
+			if err := run_command("as", "-o", obj, asm); err != nil {
+				os.Remove(obj)
+				return err
+			}
+			objs = append(objs, obj)
+		default:
+			objs = append(objs, name)
+		}
+	}
+	if cpponly || dumpasm || dontlink {
+		return nil
+	}
+	if outfile == "" {
+		outfile = "a.out"
+	}
+	args := append([]string{"-no-pie", "-o", outfile}, objs...)
+	if err := run_command("cc", args...); err != nil {
+		os.Remove(outfile)
+		return err
+	}
+	return nil
+}
 
-	return
+func main() {
+	parse_opts(os.Args[1:])
+	if len(infiles) == 0 {
+		if dontlink {
+			fatalf("no input files")
+		}
+		// Compile the standard input to the standard output.
+		initStdin()
+		compile_to("(stdin)", stdin, outfile)
+		return
+	}
+	if len(infiles) == 1 && filepath.Ext(infiles[0]) == ".c" && (cpponly || dumpasm || wantast) {
+		compile_file(infiles[0])
+		return
+	}
+	if err := drive(); err != nil {
+		fatalf("%s", err)
+	}
 }
@@ -2,34 +2,44 @@ package main
 
 import (
 	"errors"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 )
 
-const MAX_ARGS = 6
 const MAX_OP_PRIO = 16
-const MAX_ALIGN = 16
 
 var gstrings []*Ast
+// declarations of the static variables in the function being read
+var static_locals []*Ast
 var flonums []*Ast
 var globalenv = &Dict{}
 var localenv *Dict
-var struct_defs Dict
-var union_defs Dict
-var typedefs Dict
+// struct, union and enum tags in the current scope
+var tagenv = MakeDict(nil)
 var localvars []*Ast
 var current_func_type *Ctype
+var current_switch *Ast
+var labels *Dict
+var gotos []*Ast
 var labelseq = 0
-
-var ctype_void = &Ctype{typ: CTYPE_VOID, size: 0, sig: true,}
-var ctype_char = &Ctype{typ: CTYPE_CHAR, size: 1, sig: true,}
-var ctype_short = &Ctype{typ: CTYPE_SHORT, size: 2, sig: true}
-var ctype_int = &Ctype{typ: CTYPE_INT, size: 4, sig: true,}
-var ctype_long = &Ctype{typ: CTYPE_LONG, size: 8, sig: true,}
-var ctype_float = &Ctype{typ: CTYPE_FLOAT, size: 4, sig: true,}
-var ctype_double = &Ctype{typ: CTYPE_DOUBLE, size: 8, sig: true,}
-
-var ctype_ulong = &Ctype{typ: CTYPE_LONG, size: 8, sig: false,}
+var tmpseq = 0
+
+var ctype_void = &Ctype{typ: CTYPE_VOID, size: 0, align: 1, sig: true,}
+var ctype_char = &Ctype{typ: CTYPE_CHAR, size: 1, align: 1, sig: true,}
+var ctype_short = &Ctype{typ: CTYPE_SHORT, size: 2, align: 2, sig: true}
+var ctype_int = &Ctype{typ: CTYPE_INT, size: 4, align: 4, sig: true,}
+var ctype_long = &Ctype{typ: CTYPE_LONG, size: 8, align: 8, sig: true,}
+var ctype_float = &Ctype{typ: CTYPE_FLOAT, size: 4, align: 4, sig: true,}
+var ctype_double = &Ctype{typ: CTYPE_DOUBLE, size: 8, align: 8, sig: true,}
+var ctype_ldouble = &Ctype{typ: CTYPE_LDOUBLE, size: 16, align: 16, sig: true}
+
+var ctype_ushort = &Ctype{typ: CTYPE_SHORT, size: 2, align: 2, sig: false}
+var ctype_uint = &Ctype{typ: CTYPE_INT, size: 4, align: 4, sig: false,}
+var ctype_ulong = &Ctype{typ: CTYPE_LONG, size: 8, align: 8, sig: false,}
+var ctype_llong = &Ctype{typ: CTYPE_LLONG, size: 8, align: 8, sig: true}
+var ctype_ullong = &Ctype{typ: CTYPE_LLONG, size: 8, align: 8, sig: false}
 
 const (
 	S_TYPEDEF int = iota + 1
@@ -57,7 +67,14 @@ func ast_uop(typ int, ctype *Ctype, operand *Ast) *Ast {
 func ast_binop(typ int, left *Ast, right *Ast) *Ast {
 	r := &Ast{}
 	r.typ = typ
-	r.ctype = result_type(byte(typ), left.ctype, right.ctype)
+	r.ctype = result_type(typ, left.ctype, right.ctype)
+	if typ == '=' {
+		// The type of an assignment is that of its left operand.
+		r.ctype = left.ctype
+	}
+	if is_comparison(typ) {
+		r.ctype = ctype_int
+	}
 	if typ != '=' && convert_array(left.ctype).typ != CTYPE_PTR &&
 		convert_array(right.ctype).typ == CTYPE_PTR {
 		r.left = right
@@ -69,6 +86,67 @@ func ast_binop(typ int, left *Ast, right *Ast) *Ast {
 	return r
 }
 
+// ast_assign_op makes "a op= b". It is evaluated as "a = a op b"
+// except that a is evaluated only once: unless a is a plain variable,
+// its address is stored to a temporary variable first, and a is
+// read and written through that pointer.
+func ast_assign_op(op int, left *Ast, right *Ast) *Ast {
+	if convert_array(left.ctype).typ != CTYPE_PTR &&
+		convert_array(right.ctype).typ == CTYPE_PTR {
+		errorf("incompatible operands: <%s> and <%s>", left.ctype, right.ctype)
+	}
+	r := &Ast{}
+	r.typ = op
+	r.ctype = left.ctype
+	target := left
+	if left.typ == AST_STRUCT_REF && left.ctype.bitsize > 0 {
+		// A bit-field has no address, so its struct is used instead.
+		struc := left.struc
+		if struc.typ != AST_LVAR && struc.typ != AST_GVAR {
+			tmp := ast_lvar(make_ptr_type(struc.ctype), make_tempname())
+			r.init = ast_decl(tmp, ast_uop(AST_ADDR, tmp.ctype, struc))
+			struc = ast_uop(AST_DEREF, struc.ctype, tmp)
+		}
+		target = ast_struct_ref(left.ctype, struc, left.field)
+	} else if left.typ != AST_LVAR && left.typ != AST_GVAR {
+		tmp := ast_lvar(make_ptr_type(left.ctype), make_tempname())
+		r.init = ast_decl(tmp, ast_uop(AST_ADDR, tmp.ctype, left))
+		target = ast_uop(AST_DEREF, left.ctype, tmp)
+	}
+	r.left = target
+	r.right = ast_binop(assign_op_binop(op), target, right)
+	result_type('=', r.left.ctype, r.right.ctype)
+	return r
+}
+
+// ast_inc_dec makes a prefix or postfix increment or decrement.
+// It shares the representation of "a += 1", so the operand is
+// evaluated only once and pointers are scaled by the element size.
+func ast_inc_dec(typ int, operand *Ast) *Ast {
+	if !is_inttype(operand.ctype) && !is_flotype(operand.ctype) &&
+		operand.ctype.typ != CTYPE_PTR {
+		errorf("scalar type expected, but got %s", operand.ctype)
+	}
+	op := OP_A_ADD
+	if typ == OP_PRE_DEC || typ == OP_POST_DEC {
+		op = OP_A_SUB
+	}
+	r := ast_assign_op(op, operand, ast_inttype(ctype_int, 1))
+	r.typ = typ
+	return r
+}
+
+func ast_cast(ctype *Ctype, operand *Ast) *Ast {
+	from := convert_array(operand.ctype)
+	if ctype.typ != CTYPE_VOID && !is_scalar(ctype) {
+		errorf("cannot cast to %s", ctype)
+	}
+	if ctype.typ != CTYPE_VOID && !is_scalar(from) {
+		errorf("cannot cast %s to %s", operand.ctype, ctype)
+	}
+	return ast_uop(OP_CAST, ctype, operand)
+}
+
 func ast_inttype(ctype *Ctype, val int) *Ast {
 	r := &Ast{}
 	r.typ = AST_LITERAL
@@ -77,11 +155,17 @@ func ast_inttype(ctype *Ctype, val int) *Ast {
 	return r
 }
 
-func ast_double(val float64) *Ast {
+func ast_floattype(ctype *Ctype, val float64) *Ast {
+	if ctype.typ == CTYPE_FLOAT {
+		val = float64(float32(val))
+	}
 	r := &Ast{}
 	r.typ = AST_LITERAL
-	r.ctype = ctype_double
+	r.ctype = ctype
 	r.fval = val
+	if ctype.typ == CTYPE_LDOUBLE {
+		r.lval = new_ldouble().SetFloat64(val)
+	}
 	flonums = append(flonums, r)
 	return r
 }
@@ -92,6 +176,12 @@ func make_label() string {
 	return s
 }
 
+func make_tempname() string {
+	s := format(".tmp%d", tmpseq)
+	tmpseq++
+	return s
+}
+
 func ast_lvar(ctype *Ctype, name string) *Ast {
 	r := &Ast{}
 	r.typ = AST_LVAR
@@ -99,6 +189,10 @@ func ast_lvar(ctype *Ctype, name string) *Ast {
 	r.varname = name
 	localenv.PutAst(name, r)
 	if localvars != nil {
+		// The stack frame is aligned only to 16 bytes.
+		if ctype.align > 16 {
+			errorf("alignment of a local variable must be at most 16, but got %d", ctype.align)
+		}
 		localvars = append(localvars, r)
 	}
 
@@ -107,6 +201,32 @@ func ast_lvar(ctype *Ctype, name string) *Ast {
 
 type MakeVarFn func(ctype *Ctype, name string) *Ast
 
+// env returns the scope of variables and typedefs in the current block.
+func env() *Dict {
+	if localenv != nil {
+		return localenv
+	}
+	return globalenv
+}
+
+// get_typedef returns the type a typedef name stands for, or nil if
+// name is not a typedef name in the current scope.
+func get_typedef(name string) *Ctype {
+	return env().GetCtype(name)
+}
+
+// is_incomplete returns true for a struct or union whose members are
+// not known yet, and for an array of such a type.
+func is_incomplete(ctype *Ctype) bool {
+	switch ctype.typ {
+	case CTYPE_STRUCT:
+		return ctype.fields == nil
+	case CTYPE_ARRAY:
+		return is_incomplete(ctype.ptr)
+	}
+	return false
+}
+
 func define_struct_union_field(opaque *Dict, ctype *Ctype, name string) {
 	opaque.PutCtype(name, ctype)
 }
@@ -121,15 +241,42 @@ func ast_gvar(ctype *Ctype, name string) *Ast {
 	return r
 }
 
-func ast_string(str string) *Ast {
+// ast_local_gvar makes a variable with static storage duration
+// declared in a block. It is visible only in the block.
+func ast_local_gvar(ctype *Ctype, name string, label string) *Ast {
+	r := &Ast{}
+	r.typ = AST_GVAR
+	r.ctype = ctype
+	r.varname = name
+	r.glabel = label
+	localenv.PutAst(name, r)
+	return r
+}
+
+func ast_string(enc int, chars []int) *Ast {
 	r := &Ast{}
 	r.typ = AST_STRING
-	r.ctype = make_array_type(ctype_char, len(str)+1)
-	r.val = str
+	r.ctype = make_array_type(enc_type(enc), len(chars)+1)
+	r.chars = chars
+	r.enc = enc
 	r.slabel = make_label()
 	return r
 }
 
+// enc_type returns the type of the characters in the encoding. wchar_t,
+// char16_t and char32_t are int, unsigned short and unsigned int.
+func enc_type(enc int) *Ctype {
+	switch enc {
+	case ENC_CHAR16:
+		return ctype_ushort
+	case ENC_CHAR32:
+		return ctype_uint
+	case ENC_WCHAR:
+		return ctype_int
+	}
+	return ctype_char
+}
+
 func ast_funcall(ctype *Ctype, fname string, args []*Ast, paramtypes []*Ctype) *Ast {
 	r := &Ast{}
 	r.typ = AST_FUNCALL
@@ -137,6 +284,32 @@ func ast_funcall(ctype *Ctype, fname string, args []*Ast, paramtypes []*Ctype) *
 	r.fname = fname
 	r.args = args
 	r.paramtypes = paramtypes
+	if ctype.typ == CTYPE_STRUCT {
+		r.retbuf = ast_lvar(ctype, make_tempname())
+	}
+	return r
+}
+
+func ast_funcptr_call(ctype *Ctype, fptr *Ast, args []*Ast, paramtypes []*Ctype) *Ast {
+	r := &Ast{}
+	r.typ = AST_FUNCPTR_CALL
+	r.ctype = ctype
+	r.fptr = fptr
+	r.args = args
+	r.paramtypes = paramtypes
+	if ctype.typ == CTYPE_STRUCT {
+		r.retbuf = ast_lvar(ctype, make_tempname())
+	}
+	return r
+}
+
+// ast_funcdesg makes a function designator, an expression
+// referring to a function by name.
+func ast_funcdesg(ctype *Ctype, fname string) *Ast {
+	r := &Ast{}
+	r.typ = AST_FUNCDESG
+	r.ctype = ctype
+	r.fname = fname
 	return r
 }
 
@@ -168,6 +341,15 @@ func ast_init_list(initlist []*Ast) *Ast {
 	return r
 }
 
+func ast_init(val *Ast, totype *Ctype, off int) *Ast {
+	r := &Ast{}
+	r.typ = AST_INIT
+	r.operand = val
+	r.totype = totype
+	r.initoff = off
+	return r
+}
+
 func ast_if(cond *Ast, then *Ast, els *Ast) *Ast {
 	r := &Ast{}
 	r.typ = AST_IF
@@ -199,6 +381,65 @@ func ast_for(init *Ast, cond *Ast, step *Ast, body *Ast) *Ast {
 	return r
 }
 
+func ast_while(cond *Ast, body *Ast) *Ast {
+	r := &Ast{}
+	r.typ = AST_WHILE
+	r.ctype = nil
+	r.cond = cond
+	r.body = body
+	return r
+}
+
+func ast_do(cond *Ast, body *Ast) *Ast {
+	r := &Ast{}
+	r.typ = AST_DO
+	r.ctype = nil
+	r.cond = cond
+	r.body = body
+	return r
+}
+
+func ast_jump(typ int) *Ast {
+	r := &Ast{}
+	r.typ = typ
+	r.ctype = nil
+	return r
+}
+
+func ast_switch(cond *Ast) *Ast {
+	r := &Ast{}
+	r.typ = AST_SWITCH
+	r.ctype = nil
+	r.cond = cond
+	return r
+}
+
+func ast_case(typ int, val int) *Ast {
+	r := &Ast{}
+	r.typ = typ
+	r.ctype = nil
+	r.ival = val
+	r.label = make_label()
+	return r
+}
+
+func ast_goto(labelname string) *Ast {
+	r := &Ast{}
+	r.typ = AST_GOTO
+	r.ctype = nil
+	r.labelname = labelname
+	return r
+}
+
+func ast_label(labelname string) *Ast {
+	r := &Ast{}
+	r.typ = AST_LABEL
+	r.ctype = nil
+	r.labelname = labelname
+	r.label = make_label()
+	return r
+}
+
 func ast_return(rettype *Ctype, retval *Ast) *Ast {
 	r := &Ast{}
 	r.typ = AST_RETURN
@@ -248,14 +489,18 @@ func make_type(typ int, sig bool) *Ctype {
 	case CTYPE_LLONG:
 		r.size = 8
 	case CTYPE_FLOAT:
-		r.size = 8
+		r.size = 4
 	case CTYPE_DOUBLE:
 		r.size = 8
 	case CTYPE_LDOUBLE:
-		r.size = 8
+		r.size = 16
 	default:
 		errorf("internal error")
 	}
+	r.align = r.size
+	if typ == CTYPE_VOID {
+		r.align = 1
+	}
 	return r
 }
 
@@ -264,6 +509,7 @@ func make_ptr_type(ctype *Ctype) *Ctype {
 	r.typ = CTYPE_PTR
 	r.ptr = ctype
 	r.size = 8
+	r.align = 8
 	return r
 }
 
@@ -276,6 +522,7 @@ func make_array_type(ctype *Ctype, len int) *Ctype {
 	} else {
 		r.size = r.ptr.size * len
 	}
+	r.align = r.ptr.align
 	r.len = len
 	return r
 }
@@ -287,11 +534,12 @@ func make_struct_field_type(ctype *Ctype, offset int) *Ctype {
 	return r
 }
 
-func make_struct_type(fields *Dict, size int) *Ctype {
+func make_struct_type(fields *Dict, size int, align int) *Ctype {
 	r := &Ctype{}
 	r.typ = CTYPE_STRUCT
 	r.fields = fields
 	r.size = size
+	r.align = align
 	return r
 }
 
@@ -321,6 +569,32 @@ func is_flotype(ctype *Ctype) bool {
 		ctype.typ == CTYPE_LDOUBLE
 }
 
+func is_scalar(ctype *Ctype) bool {
+	return is_inttype(ctype) || is_flotype(ctype) || ctype.typ == CTYPE_PTR
+}
+
+// cast_intval converts an integer value to the given integer type.
+func cast_intval(ctype *Ctype, val int) int {
+	switch ctype.size {
+	case 1:
+		if ctype.sig {
+			return int(int8(val))
+		}
+		return int(uint8(val))
+	case 2:
+		if ctype.sig {
+			return int(int16(val))
+		}
+		return int(uint16(val))
+	case 4:
+		if ctype.sig {
+			return int(int32(val))
+		}
+		return int(uint32(val))
+	}
+	return val
+}
+
 func ensure_lvalue(ast *Ast) {
 	switch ast.typ {
 	case AST_LVAR, AST_GVAR, AST_DEREF, AST_STRUCT_REF:
@@ -330,6 +604,58 @@ func ensure_lvalue(ast *Ast) {
 	return
 }
 
+// is_const returns true if an object of the type may not be assigned
+// as a whole: it is const-qualified, or has a const-qualified element or
+// member. Other members of such a struct may still be assigned.
+func is_const(ctype *Ctype) bool {
+	if ctype.isconst {
+		return true
+	}
+	switch ctype.typ {
+	case CTYPE_ARRAY:
+		return is_const(ctype.ptr)
+	case CTYPE_STRUCT:
+		if ctype.fields == nil {
+			return false
+		}
+		for _, v := range ctype.fields.Values() {
+			if is_const(v.ctype) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// is_readonly returns true if an object of the type can be placed in
+// read-only memory: it is const-qualified, or an array of such objects.
+// A struct with a const member is not, since its other members may be
+// modified.
+func is_readonly(ctype *Ctype) bool {
+	if ctype.isconst {
+		return true
+	}
+	if ctype.typ == CTYPE_ARRAY {
+		return is_readonly(ctype.ptr)
+	}
+	return false
+}
+
+// ensure_assignable checks that ast is an lvalue that may be modified.
+// A member of a const struct is read-only as well, but other members
+// of a struct with a const member are not.
+func ensure_assignable(ast *Ast) {
+	ensure_lvalue(ast)
+	if is_const(ast.ctype) {
+		errorf("assignment of read-only location: %s", ast)
+	}
+	for v := ast; v.typ == AST_STRUCT_REF; v = v.struc {
+		if v.struc.ctype.isconst {
+			errorf("assignment of read-only location: %s", ast)
+		}
+	}
+}
+
 func expect(punct byte) {
 	tok := read_token()
 	if !tok.is_punct(int(punct)) {
@@ -342,10 +668,104 @@ func (tok *Token) is_ident(s string) bool {
 }
 
 func is_right_assoc(tok *Token) bool {
-	return tok.punct == '='
+	return tok.punct == '=' || is_assign_op(tok.punct)
+}
+
+func is_assign_op(op int) bool {
+	return assign_op_binop(op) != 0
+}
+
+// assign_op_binop returns the binary operator of a compound
+// assignment operator, or 0 if op is not one.
+func assign_op_binop(op int) int {
+	switch op {
+	case OP_A_ADD:
+		return '+'
+	case OP_A_SUB:
+		return '-'
+	case OP_A_MUL:
+		return '*'
+	case OP_A_DIV:
+		return '/'
+	case OP_A_MOD:
+		return '%'
+	case OP_A_AND:
+		return '&'
+	case OP_A_OR:
+		return '|'
+	case OP_A_XOR:
+		return '^'
+	case OP_A_SAL:
+		return OP_SAL
+	case OP_A_SAR:
+		return OP_SAR
+	}
+	return 0
+}
+
+
+// eval_arith computes "l op r" in the given type. Both operands are
+// converted to the type first, and unsigned types use unsigned division.
+func eval_arith(op int, ctype *Ctype, l int, r int) int {
+	l = cast_intval(ctype, l)
+	r = cast_intval(ctype, r)
+	var v int
+	switch op {
+	case '+':
+		v = l + r
+	case '-':
+		v = l - r
+	case '*':
+		v = l * r
+	case '/', '%':
+		if r == 0 {
+			errorf("division by zero")
+		}
+		switch {
+		case !ctype.sig && op == '/':
+			v = int(uint64(l) / uint64(r))
+		case !ctype.sig:
+			v = int(uint64(l) % uint64(r))
+		case op == '/':
+			v = l / r
+		default:
+			v = l % r
+		}
+	case '&':
+		v = l & r
+	case '|':
+		v = l | r
+	case '^':
+		v = l ^ r
+	}
+	return cast_intval(ctype, v)
+}
+
+// eval_comp compares l and r after converting them to the given type.
+func eval_comp(op int, ctype *Ctype, l int, r int) int {
+	l = cast_intval(ctype, l)
+	r = cast_intval(ctype, r)
+	if !ctype.sig {
+		// Flip the sign bits so that signed comparison
+		// gives the unsigned result.
+		l ^= -1 << 63
+		r ^= -1 << 63
+	}
+	switch op {
+	case '<':
+		return bool2int(l < r)
+	case '>':
+		return bool2int(l > r)
+	case OP_EQ:
+		return bool2int(l == r)
+	case OP_GE:
+		return bool2int(l >= r)
+	case OP_LE:
+		return bool2int(l <= r)
+	}
+	return bool2int(l != r)
 }
 
-
 func E(ast *Ast) int {
 	return eval_intexpr(ast)
 }
@@ -368,16 +788,29 @@ func eval_intexpr(ast *Ast) int {
 		} else {
 			return E(ast.els)
 		}
-	case '+': return E(L) + E(R)
-	case '-': return E(L) - E(R)
-	case '*': return E(L) * E(R)
-	case '/': return E(L) / E(R)
-	case '<': return bool2int(E(L) < E(R))
-	case '>': return bool2int(E(L) > E(R))
-	case OP_EQ: return bool2int(E(L) == E(R))
-	case OP_GE: return bool2int(E(L) >= E(R))
-	case OP_LE: return bool2int(E(L) <= E(R))
-	case OP_NE: return bool2int(E(L) != E(R))
+	case '~':
+		return cast_intval(ast.ctype, ^E(ast.operand))
+	case OP_CAST:
+		if !is_inttype(ast.ctype) {
+			errorf("Integer expression expected, but got %s", ast)
+		}
+		operand := ast.operand
+		if operand.typ == AST_LITERAL && is_flotype(operand.ctype) {
+			return cast_intval(ast.ctype, int(operand.fval))
+		}
+		return cast_intval(ast.ctype, E(operand))
+	case '+', '-', '*', '/', '%', '&', '|', '^':
+		return eval_arith(ast.typ, ast.ctype, E(L), E(R))
+	case OP_SAL:
+		return cast_intval(ast.ctype, cast_intval(ast.ctype, E(L)) << uint(E(R)))
+	case OP_SAR:
+		l := cast_intval(ast.ctype, E(L))
+		if !ast.ctype.sig {
+			return int(uint64(l) >> uint(E(R)))
+		}
+		return l >> uint(E(R))
+	case '<', '>', OP_EQ, OP_GE, OP_LE, OP_NE:
+		return eval_comp(ast.typ, result_type(ast.typ, L.ctype, R.ctype), E(L), E(R))
 	case OP_LOGAND: return E(L) * E(R)
 	case OP_LOGOR: return bool2int(int2bool(E(L)) || int2bool(E(R)))
 	default:
@@ -386,31 +819,208 @@ func eval_intexpr(ast *Ast) int {
 	return -1
 }
 
+// eval_floatexpr evaluates a constant expression of a floating point
+// type, or of an integer type converted to one. A float is rounded to
+// single precision.
+func eval_floatexpr(ast *Ast) float64 {
+	v := eval_floatexpr_int(ast)
+	if ast.ctype.typ == CTYPE_FLOAT {
+		return float64(float32(v))
+	}
+	return v
+}
+
+func eval_floatexpr_int(ast *Ast) float64 {
+	if is_inttype(ast.ctype) {
+		v := eval_intexpr(ast)
+		if !ast.ctype.sig && ast.ctype.size == 8 {
+			return float64(uint64(v))
+		}
+		return float64(v)
+	}
+	if !is_flotype(ast.ctype) {
+		errorf("Floating point expression expected, but got %s", ast)
+	}
+	if ast.ctype.typ == CTYPE_LDOUBLE {
+		v, _ := eval_ldoubleexpr(ast).Float64()
+		return v
+	}
+	switch ast.typ {
+	case AST_LITERAL:
+		return ast.fval
+	case OP_CAST:
+		return eval_floatexpr(ast.operand)
+	case AST_TERNARY:
+		if int2bool(E(ast.cond)) {
+			return eval_floatexpr(ast.then)
+		}
+		return eval_floatexpr(ast.els)
+	case '+':
+		return eval_floatexpr(ast.left) + eval_floatexpr(ast.right)
+	case '-':
+		return eval_floatexpr(ast.left) - eval_floatexpr(ast.right)
+	case '*':
+		return eval_floatexpr(ast.left) * eval_floatexpr(ast.right)
+	case '/':
+		return eval_floatexpr(ast.left) / eval_floatexpr(ast.right)
+	}
+	errorf("Floating point expression expected, but got %s", ast)
+	return 0
+}
+
+// new_ldouble returns a zero with the precision of a long double.
+func new_ldouble() *big.Float {
+	return new(big.Float).SetPrec(64)
+}
+
+// eval_ldoubleexpr evaluates a constant expression as a long double.
+// The values of the narrower types are rounded to their precisions.
+func eval_ldoubleexpr(ast *Ast) *big.Float {
+	r := new_ldouble()
+	if ast.ctype.typ != CTYPE_LDOUBLE {
+		if is_inttype(ast.ctype) && !ast.ctype.sig && ast.ctype.size == 8 {
+			return r.SetUint64(uint64(eval_intexpr(ast)))
+		}
+		return r.SetFloat64(eval_floatexpr(ast))
+	}
+	switch ast.typ {
+	case AST_LITERAL:
+		return r.Set(ast.lval)
+	case OP_CAST:
+		return eval_ldoubleexpr(ast.operand)
+	case AST_TERNARY:
+		if int2bool(E(ast.cond)) {
+			return eval_ldoubleexpr(ast.then)
+		}
+		return eval_ldoubleexpr(ast.els)
+	case '+':
+		return r.Add(eval_ldoubleexpr(ast.left), eval_ldoubleexpr(ast.right))
+	case '-':
+		return r.Sub(eval_ldoubleexpr(ast.left), eval_ldoubleexpr(ast.right))
+	case '*':
+		return r.Mul(eval_ldoubleexpr(ast.left), eval_ldoubleexpr(ast.right))
+	case '/':
+		return r.Quo(eval_ldoubleexpr(ast.left), eval_ldoubleexpr(ast.right))
+	}
+	errorf("Floating point expression expected, but got %s", ast)
+	return nil
+}
+
+// eval_static_addr evaluates an address constant to a label and an
+// offset from it. The label is empty for an integer cast to a pointer.
+func eval_static_addr(ast *Ast) (string, int) {
+	switch ast.typ {
+	case AST_STRING:
+		return ast.slabel, 0
+	case AST_FUNCDESG:
+		return ast.fname, 0
+	case AST_GVAR:
+		if ast.ctype.typ == CTYPE_ARRAY {
+			return ast.glabel, 0
+		}
+	case AST_ADDR:
+		return eval_static_lvalue(ast.operand)
+	case OP_CAST:
+		if is_inttype(ast.operand.ctype) {
+			return "", eval_intexpr(ast.operand)
+		}
+		return eval_static_addr(ast.operand)
+	case '+', '-':
+		if convert_array(ast.left.ctype).typ != CTYPE_PTR {
+			break
+		}
+		label, off := eval_static_addr(ast.left)
+		n := eval_intexpr(ast.right) * ast.left.ctype.ptr.size
+		if ast.typ == '-' {
+			return label, off - n
+		}
+		return label, off + n
+	default:
+		if is_inttype(ast.ctype) {
+			return "", eval_intexpr(ast)
+		}
+	}
+	errorf("initializer element is not constant: %s", ast)
+	return "", 0
+}
+
+// eval_static_lvalue evaluates the address of an object with static
+// storage duration.
+func eval_static_lvalue(ast *Ast) (string, int) {
+	switch ast.typ {
+	case AST_GVAR:
+		return ast.glabel, 0
+	case AST_FUNCDESG:
+		return ast.fname, 0
+	case AST_STRUCT_REF:
+		label, off := eval_static_lvalue(ast.struc)
+		return label, off + ast.ctype.offset
+	case AST_DEREF:
+		return eval_static_addr(ast.operand)
+	}
+	errorf("initializer element is not constant: %s", ast)
+	return "", 0
+}
+
+// eval_static_init evaluates the initializer of a scalar with static
+// storage duration. Arithmetic values are replaced by literals of the
+// type; addresses are checked and kept as they are.
+func eval_static_init(ast *Ast, ctype *Ctype) *Ast {
+	switch {
+	case is_inttype(ctype) && is_flotype(ast.ctype):
+		return ast_inttype(ctype, cast_intval(ctype, int(eval_floatexpr(ast))))
+	case is_inttype(ctype):
+		return ast_inttype(ctype, cast_intval(ctype, eval_intexpr(ast)))
+	case ctype.typ == CTYPE_LDOUBLE:
+		r := &Ast{}
+		r.typ = AST_LITERAL
+		r.ctype = ctype
+		r.lval = eval_ldoubleexpr(ast)
+		r.fval, _ = r.lval.Float64()
+		return r
+	case is_flotype(ctype):
+		r := &Ast{}
+		r.typ = AST_LITERAL
+		r.ctype = ctype
+		r.fval = eval_floatexpr(ast)
+		if ctype.typ == CTYPE_FLOAT {
+			r.fval = float64(float32(r.fval))
+		}
+		return r
+	case ctype.typ == CTYPE_PTR:
+		eval_static_addr(ast)
+		return ast
+	}
+	errorf("initializer element is not constant: %s", ast)
+	return nil
+}
+
 func priority(tok *Token) int {
 	switch tok.punct {
-	case '[', '.', OP_ARROW:
-		return 1
-	case OP_INC, OP_DEC:
-		return 2
-	case '*', '/':
+	case '*', '/', '%':
 		return 3
 	case '+', '-':
 		return 4
-	case '<', '>', OP_LE, OP_GE, OP_NE:
+	case OP_SAL, OP_SAR:
+		return 5
+	case '<', '>', OP_LE, OP_GE:
 		return 6
+	case OP_EQ, OP_NE:
+		return 7
 	case '&':
 		return 8
-	case '|':
+	case '^':
 		return 9
-	case OP_EQ:
-		return 7
+	case '|':
+		return 10
 	case OP_LOGAND:
 		return 11
 	case OP_LOGOR:
 		return 12
 	case '?':
 		return 13
-	case '=':
+	case '=', OP_A_ADD, OP_A_SUB, OP_A_MUL, OP_A_DIV, OP_A_MOD,
+		OP_A_AND, OP_A_OR, OP_A_XOR, OP_A_SAL, OP_A_SAR:
 		return 14
 	default:
 		return -1
@@ -425,21 +1035,25 @@ func param_types(params []*Ast) []*Ctype {
 	return r
 }
 
-func function_type_check(fname string, params []*Ctype, args []*Ctype) {
+func function_type_check(fname string, ftype *Ctype, args []*Ctype) {
+	params := ftype.params
 	if len(args) < len(params) {
 		errorf("Too few arguments: %s", fname)
 	}
+	if len(args) > len(params) && !ftype.hasva && !ftype.oldstyle {
+		errorf("Too many arguments: %s", fname)
+	}
 	for i, arg := range args {
 		if i < len(params) {
 			param := params[i]
 			result_type('=', param, arg)
-		} else {
-			result_type('=', arg, ctype_int)
+		} else if !is_scalar(convert_array(arg)) && arg.typ != CTYPE_STRUCT {
+			errorf("invalid argument type: %s: %s", fname, arg)
 		}
 	}
 }
 
-func read_func_args(fname string) *Ast {
+func read_func_args() []*Ast {
 	var args []*Ast
 	for {
 		tok := read_token()
@@ -456,31 +1070,114 @@ func read_func_args(fname string) *Ast {
 			errorf("Unexpected token: '%s'", tok)
 		}
 	}
-	if MAX_ARGS < len(args) {
-		errorf("Too many arguments: %s", fname)
+	return args
+}
+
+// read_funcall reads a call of fn, which is either a function
+// designator or an expression of pointer to function type. The
+// former is called directly and the latter through the pointer.
+func read_funcall(fn *Ast) *Ast {
+	ftype := fn.ctype
+	if ftype.typ == CTYPE_PTR {
+		ftype = ftype.ptr
 	}
-	fnc := localenv.GetAst(fname)
-	if fnc != nil {
-		t := fnc.ctype
-		if t.typ != CTYPE_FUNC {
-			errorf("%s is not a function, but %s", fname, t)
-		}
-		function_type_check(fname, t.params, param_types(args))
-		return ast_funcall(t.rettype, fname, args, t.params)
+	if ftype.typ != CTYPE_FUNC {
+		errorf("%s is not a function, but %s", fn, fn.ctype)
+	}
+	args := read_func_args()
+	function_type_check(fn.String(), ftype, param_types(args))
+	if fn.typ == AST_FUNCDESG {
+		return ast_funcall(ftype.rettype, fn.fname, args, ftype.params)
 	}
-	return ast_funcall(ctype_int, fname, args, nil)
+	return ast_funcptr_call(ftype.rettype, fn, args, ftype.params)
 }
 
-func read_ident_or_func(name string) *Ast {
-	ch := read_token()
-	if ch.is_punct('(') {
-		return read_func_args(name)
+// read_va_list reads an argument of type va_list.
+func read_va_list() *Ast {
+	ap := read_expr()
+	ctype := convert_array(ap.ctype)
+	if ctype.typ != CTYPE_PTR || ctype.ptr != va_list_type().ptr {
+		errorf("va_list expected, but got %s", ap.ctype)
+	}
+	return ap
+}
+
+func va_list_type() *Ctype {
+	return globalenv.GetCtype("__builtin_va_list")
+}
+
+func read_va_start() *Ast {
+	if current_func_type == nil || !current_func_type.hasva {
+		errorf("va_start used in a function with fixed arguments")
+	}
+	expect('(')
+	ap := read_va_list()
+	expect(',')
+	read_expr()
+	expect(')')
+	return ast_uop(AST_VA_START, ctype_void, ap)
+}
+
+func read_va_arg() *Ast {
+	expect('(')
+	ap := read_va_list()
+	expect(',')
+	basetype, _ := read_decl_spec()
+	var name string
+	ctype, _ := read_declarator(&name, basetype, nil, DECL_CAST)
+	expect(')')
+	if !is_scalar(ctype) && ctype.typ != CTYPE_STRUCT {
+		errorf("cannot read %s by va_arg", ctype)
+	}
+	r := ast_uop(AST_VA_ARG, ctype, ap)
+	if ctype.typ == CTYPE_STRUCT {
+		r.retbuf = ast_lvar(ctype, make_tempname())
 	}
-	unget_token(ch)
+	return r
+}
 
-	v := localenv.GetAst(name)
+func read_va_end() *Ast {
+	expect('(')
+	ap := read_va_list()
+	expect(')')
+	return ast_cast(ctype_void, ap)
+}
+
+// read_va_copy makes "*dst = *src", which copies the va_list struct.
+func read_va_copy() *Ast {
+	expect('(')
+	dst := read_va_list()
+	expect(',')
+	src := read_va_list()
+	expect(')')
+	elem := va_list_type().ptr
+	return ast_binop('=', ast_uop(AST_DEREF, elem, dst), ast_uop(AST_DEREF, elem, src))
+}
+
+func read_ident_or_func(name string) *Ast {
+	switch name {
+	case "__builtin_va_start":
+		return read_va_start()
+	case "__builtin_va_arg":
+		return read_va_arg()
+	case "__builtin_va_end":
+		return read_va_end()
+	case "__builtin_va_copy":
+		return read_va_copy()
+	}
+	v := env().GetAst(name)
 	if v == nil {
-		errorf("Undefined varaible: %s", name)
+		if !peek_token().is_punct('(') {
+			errorf("Undefined varaible: %s", name)
+		}
+		// A call of an undeclared function implicitly
+		// declares it as a function returning int.
+		ftype := make_func_type(ctype_int, nil, false)
+		ftype.oldstyle = true
+		return ast_funcdesg(ftype, name)
+	}
+	if v.ctype.typ == CTYPE_FUNC {
+		return ast_funcdesg(v.ctype, name)
 	}
 	return v
 }
@@ -494,63 +1191,187 @@ func is_long_token(s string) bool {
 	return false
 }
 
-func atol(sval string) int {
-	s := strings.TrimSuffix(sval, "L")
-	i, _ := strconv.Atoi(s)
-	return i
+// read_number_ast converts a preprocessing number to an integer or a
+// floating point constant.
+func read_number_ast(sval string) *Ast {
+	if is_float_number(sval) {
+		return read_float_ast(sval)
+	}
+	return read_int_ast(sval)
 }
 
-func read_number_ast(sval string) *Ast {
-	assert(sval[0] > 0)
-	index := 0
+func is_float_number(s string) bool {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strings.ContainsAny(s, ".pP")
+	}
+	return strings.ContainsAny(s, ".eE")
+}
+
+// read_int_ast converts a decimal, octal, hexadecimal or binary integer
+// constant. Its type is the first in the list for its suffix that can
+// represent the value.
+func read_int_ast(s string) *Ast {
 	base := 10
-	if sval[0] == '0' {
-		index++
-		if index < len(sval) && (sval[index] == 'x' || sval[index] == 'X') {
-			base = 16
-			index++
-		} else if index < len(sval) && isdigit(sval[index]) {
-			base = 8
-		}
-	}
-	start := index
-	for index < len(sval) && (isdigit(sval[index]) ||
-		/* 'a' <= && 'f' <= looks to be a bug !! */
-		(base == 16 && (('a' <=  sval[index] && 'f' <= sval[index])|| 'A' <= sval[index] && 'F' <= sval[index]) )) {
-		index++
-	}
-	if index < len(sval) && sval[index] == '.' {
-		if base != 10 {
-			errorf("malformed number: %s", sval)
-		}
-		index++
-		for index < len(sval) && isdigit(sval[index]) {
-			index++
-		}
-		if index < len(sval) && sval[index] != byte(0) {
-			errorf("malformed number: %s", sval)
-		}
-		end := index - 1
-		assert(start != end)
-		fval, _ := strconv.ParseFloat(sval, 64)
-		return ast_double(fval)
-	}
-	if index < len(sval) && (sval[index] == 'l' || sval[index] == 'L') {
-		ival := atol(sval)
-		return ast_inttype(ctype_long, ival)
-	} else if  index < len(sval) && (sval[index:index+1] == "ul" || sval[index:index+1] == "ul") {
-		val, _ := strconv.ParseInt(sval, base, 0)
-		return ast_inttype(ctype_long, int(val))
-	} else {
-		if index < len(sval) && sval[index] != byte(0) {
-			errorf("malformed number: %s", sval)
+	digits := s
+	switch {
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		base = 16
+		digits = s[2:]
+	case strings.HasPrefix(s, "0b") || strings.HasPrefix(s, "0B"):
+		base = 2
+		digits = s[2:]
+	case s[0] == '0':
+		base = 8
+	}
+	n := 0
+	for n < len(digits) && (isdigit(digits[n]) || base == 16 && isxdigit(digits[n])) {
+		n++
+	}
+	if n == 0 {
+		errorf("malformed number: %s", s)
+	}
+	types := int_literal_types(digits[n:], base == 10)
+	if types == nil {
+		errorf("invalid suffix \"%s\" on integer constant: %s", digits[n:], s)
+	}
+	val, err := strconv.ParseUint(digits[:n], base, 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			errorf("integer constant is too large: %s", s)
+		}
+		errorf("invalid digit in integer constant: %s", s)
+	}
+	for _, t := range types {
+		if t.sig && val <= uint64(1)<<uint(t.size*8-1)-1 || !t.sig && (t.size == 8 || val < uint64(1)<<uint(t.size*8)) {
+			return ast_inttype(t, int(val))
+		}
+	}
+	// A decimal constant without u too large for long long
+	warn("%s: integer constant is so large that it is unsigned: %s\n", input_position(), s)
+	if types[len(types)-1] == ctype_llong {
+		return ast_inttype(ctype_ullong, int(val))
+	}
+	return ast_inttype(ctype_ulong, int(val))
+}
+
+// int_literal_types returns the types that an integer constant with the
+// suffix may have, or nil if the suffix is invalid.
+func int_literal_types(suffix string, decimal bool) []*Ctype {
+	unsigned := false
+	if strings.HasPrefix(suffix, "u") || strings.HasPrefix(suffix, "U") {
+		unsigned = true
+		suffix = suffix[1:]
+	} else if strings.HasSuffix(suffix, "u") || strings.HasSuffix(suffix, "U") {
+		unsigned = true
+		suffix = suffix[:len(suffix)-1]
+	}
+	switch {
+	case suffix == "" && unsigned:
+		return []*Ctype{ctype_uint, ctype_ulong, ctype_ullong}
+	case suffix == "" && decimal:
+		return []*Ctype{ctype_int, ctype_long, ctype_llong}
+	case suffix == "":
+		return []*Ctype{ctype_int, ctype_uint, ctype_long, ctype_ulong, ctype_llong, ctype_ullong}
+	case (suffix == "l" || suffix == "L") && unsigned:
+		return []*Ctype{ctype_ulong, ctype_ullong}
+	case (suffix == "l" || suffix == "L") && decimal:
+		return []*Ctype{ctype_long, ctype_llong}
+	case suffix == "l" || suffix == "L":
+		return []*Ctype{ctype_long, ctype_ulong, ctype_llong, ctype_ullong}
+	case (suffix == "ll" || suffix == "LL") && unsigned:
+		return []*Ctype{ctype_ullong}
+	case (suffix == "ll" || suffix == "LL") && decimal:
+		return []*Ctype{ctype_llong}
+	case suffix == "ll" || suffix == "LL":
+		return []*Ctype{ctype_llong, ctype_ullong}
+	}
+	return nil
+}
+
+// read_float_ast converts a decimal or hexadecimal floating constant.
+func read_float_ast(s string) *Ast {
+	body := s
+	ctype := ctype_double
+	switch s[len(s)-1] {
+	case 'f', 'F':
+		ctype = ctype_float
+		body = s[:len(s)-1]
+	case 'l', 'L':
+		ctype = ctype_ldouble
+		body = s[:len(s)-1]
+	}
+	if !is_float_syntax(body) {
+		errorf("malformed floating constant: %s", s)
+	}
+	if ctype.typ == CTYPE_LDOUBLE {
+		lval, _, err := new_ldouble().Parse(body, 0)
+		if err != nil {
+			errorf("malformed floating constant: %s", s)
+		}
+		if lval.MantExp(nil) > 16384 {
+			warn("%s: floating constant exceeds range of long double: %s\n", input_position(), s)
+			lval.SetInf(false)
+		}
+		r := ast_floattype(ctype, 0)
+		r.lval = lval
+		r.fval, _ = lval.Float64()
+		return r
+	}
+	fval, err := strconv.ParseFloat(body, ctype.size*8)
+	if err != nil {
+		if err.(*strconv.NumError).Err != strconv.ErrRange {
+			errorf("malformed floating constant: %s", s)
+		}
+		warn("%s: floating constant exceeds range of %s: %s\n", input_position(), ctype, s)
+	}
+	return ast_floattype(ctype, fval)
+}
+
+// is_float_syntax returns true if s is a floating constant without a
+// suffix: a decimal one with a period or an exponent, or a hexadecimal
+// one with a binary exponent.
+func is_float_syntax(s string) bool {
+	hex := strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X")
+	if hex {
+		s = s[2:]
+	}
+	isdigit_base := func(c byte) bool {
+		return isdigit(c) || hex && isxdigit(c)
+	}
+	i := 0
+	ndigits := 0
+	for ; i < len(s) && isdigit_base(s[i]); i++ {
+		ndigits++
+	}
+	period := i < len(s) && s[i] == '.'
+	if period {
+		i++
+		for ; i < len(s) && isdigit_base(s[i]); i++ {
+			ndigits++
 		}
-		val, _ := strconv.ParseInt(sval, 0, 64)
-		if val >= UINT_MAX {
-			return ast_inttype(ctype_long, int(val))
+	}
+	if ndigits == 0 {
+		return false
+	}
+	if i == len(s) {
+		return period && !hex
+	}
+	if hex && s[i] != 'p' && s[i] != 'P' || !hex && s[i] != 'e' && s[i] != 'E' {
+		return false
+	}
+	i++
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	if i == len(s) {
+		return false
+	}
+	for ; i < len(s); i++ {
+		if !isdigit(s[i]) {
+			return false
 		}
-		return ast_inttype(ctype_int, int(val))
 	}
+	return true
 }
 
 func read_prim() *Ast {
@@ -564,12 +1385,24 @@ func read_prim() *Ast {
 	case TTYPE_NUMBER:
 		return read_number_ast(tok.sval)
 	case TTYPE_CHAR:
-		return ast_inttype(ctype_char, int(tok.c))
+		if tok.enc != ENC_NONE {
+			return ast_inttype(enc_type(tok.enc), tok.c)
+		}
+		if len(tok.chars) > 1 {
+			// A multi-character constant
+			return ast_inttype(ctype_int, tok.c)
+		}
+		return ast_inttype(ctype_char, tok.c)
 	case TTYPE_STRING:
-		r := ast_string(tok.sval)
+		r := ast_string(tok.enc, tok.chars)
 		gstrings = append(gstrings, r)
 		return r
 	case TTYPE_PUNCT:
+		if tok.is_punct('(') {
+			r := read_expr()
+			expect(')')
+			return r
+		}
 		unget_token(tok)
 		return nil
 	default:
@@ -579,16 +1412,96 @@ func read_prim() *Ast {
 	return nil
 }
 
-func result_type_int(op byte, a *Ctype, b *Ctype) (*Ctype, error) {
+func is_comparison(op int) bool {
+	switch op {
+	case '<', '>', OP_EQ, OP_NE, OP_LE, OP_GE:
+		return true
+	}
+	return false
+}
+
+func is_intop(op int) bool {
+	switch op {
+	case '%', '&', '|', '^', OP_SAL, OP_SAR:
+		return true
+	}
+	return false
+}
+
+// promote_int returns the type of an integer after the integer promotions.
+func promote_int(ctype *Ctype) *Ctype {
+	// A bit-field narrower than int fits in int whatever its type is.
+	if ctype.size < ctype_int.size || (0 < ctype.bitsize && ctype.bitsize < ctype_int.size*8) {
+		return ctype_int
+	}
+	return ctype
+}
+
+// usual_arith_conv returns the common type of two arithmetic operands.
+// After promotion the larger integer type wins, as it can represent
+// every value of the smaller one. If both have the same size, the one
+// of higher rank wins, and it is unsigned if either operand is.
+func usual_arith_conv(a *Ctype, b *Ctype) *Ctype {
+	if is_flotype(a) || is_flotype(b) {
+		// The wider floating point type; CTYPE_FLOAT, CTYPE_DOUBLE
+		// and CTYPE_LDOUBLE are in the order of their widths.
+		if !is_flotype(a) || (is_flotype(b) && b.typ > a.typ) {
+			return b
+		}
+		return a
+	}
+	a = promote_int(a)
+	b = promote_int(b)
+	if a.size != b.size {
+		if a.size > b.size {
+			return a
+		}
+		return b
+	}
+	if a.typ < b.typ {
+		a, b = b, a
+	}
+	if a.sig && !b.sig {
+		return make_type(a.typ, false)
+	}
+	return a
+}
+
+func result_type_int(op int, a *Ctype, b *Ctype) (*Ctype, error) {
+	default_err := errors.New("")
+	if is_intop(op) && (!is_inttype(a) || !is_inttype(b)) {
+		return nil, default_err
+	}
+	if op == OP_SAL || op == OP_SAR {
+		// The type of a shift is that of its promoted left operand.
+		return promote_int(a), nil
+	}
+	if op == OP_LOGAND || op == OP_LOGOR {
+		if !is_scalar(a) || !is_scalar(b) {
+			return nil, default_err
+		}
+		return ctype_int, nil
+	}
+	if a.typ == CTYPE_STRUCT || b.typ == CTYPE_STRUCT {
+		// Only a struct of the same type can be assigned.
+		if op == '=' && a.typ == b.typ && a.fields == b.fields {
+			return a, nil
+		}
+		return nil, default_err
+	}
+
 	if a.typ > b.typ {
 		b, a = a, b
 	}
 
-	default_err := errors.New("")
 	if b.typ == CTYPE_PTR {
 		if op == '=' {
 			return a, nil
 		}
+		if is_comparison(op) && (a.typ == CTYPE_PTR || is_inttype(a)) {
+			// Pointers are compared as unsigned integers.
+			return b, nil
+		}
 		if op != '+' && op != '-' {
 			return nil, default_err
 		}
@@ -601,37 +1514,20 @@ func result_type_int(op byte, a *Ctype, b *Ctype) (*Ctype, error) {
 	switch a.typ {
 	case CTYPE_VOID:
 		return nil, default_err
-	case CTYPE_CHAR, CTYPE_SHORT, CTYPE_INT:
-		switch b.typ {
-		case CTYPE_CHAR, CTYPE_SHORT, CTYPE_INT:
-			return ctype_int, nil
-		case CTYPE_LONG, CTYPE_LLONG:
-			return ctype_long, nil
-		case CTYPE_FLOAT, CTYPE_DOUBLE, CTYPE_LDOUBLE:
-			return ctype_double, nil
-		case CTYPE_ARRAY, CTYPE_PTR:
-			return b, nil
-		}
-		errorf("internal error")
-	case CTYPE_LONG, CTYPE_LLONG:
+	case CTYPE_CHAR, CTYPE_SHORT, CTYPE_INT, CTYPE_LONG, CTYPE_LLONG:
 		switch b.typ {
-		case CTYPE_LONG, CTYPE_LLONG:
-			return ctype_long, nil
-		case CTYPE_FLOAT, CTYPE_DOUBLE, CTYPE_LDOUBLE:
-			return ctype_double, nil
+		case CTYPE_CHAR, CTYPE_SHORT, CTYPE_INT, CTYPE_LONG, CTYPE_LLONG,
+			CTYPE_FLOAT, CTYPE_DOUBLE, CTYPE_LDOUBLE:
+			return usual_arith_conv(a, b), nil
 		case CTYPE_ARRAY, CTYPE_PTR:
 			return b, nil
 		}
-		errorf("internal error")
-	case CTYPE_FLOAT:
-		if b.typ == CTYPE_FLOAT || b.typ == CTYPE_DOUBLE || b.typ == CTYPE_LDOUBLE {
-			return ctype_double, nil
-		}
 		return nil, default_err
-	case CTYPE_DOUBLE, CTYPE_LDOUBLE:
-		if b.typ == CTYPE_DOUBLE || b.typ == CTYPE_LDOUBLE  {
-			return ctype_double, nil
+	case CTYPE_FLOAT, CTYPE_DOUBLE, CTYPE_LDOUBLE:
+		if is_flotype(b) {
+			return usual_arith_conv(a, b), nil
 		}
+		return nil, default_err
 	case CTYPE_ARRAY:
 		if b.typ != CTYPE_ARRAY {
 			return nil, default_err
@@ -652,14 +1548,19 @@ func read_subscript_expr(ast *Ast) *Ast {
 	return ast_uop(AST_DEREF, t.ctype.ptr, t)
 }
 
+// convert_array returns the pointer type an array or a function
+// is converted to when used as a value.
 func convert_array(ctype *Ctype) *Ctype {
+	if ctype.typ == CTYPE_FUNC {
+		return make_ptr_type(ctype)
+	}
 	if ctype.typ != CTYPE_ARRAY {
 		return ctype
 	}
 	return make_ptr_type(ctype.ptr)
 }
 
-func result_type(op byte, a *Ctype, b *Ctype) *Ctype {
+func result_type(op int, a *Ctype, b *Ctype) *Ctype {
 	ret, err := result_type_int(op, convert_array(a), convert_array(b))
 	if err != nil {
 		errorf("incompatible operands: %c: <%s> and <%s>",
@@ -668,25 +1569,50 @@ func result_type(op byte, a *Ctype, b *Ctype) *Ctype {
 	return ret
 }
 
-func get_sizeof_size(allow_typename bool) *Ast {
+func get_sizeof_size() *Ast {
 	tok := read_token()
-	if allow_typename && is_type_keyword(tok) {
-		unget_token(tok)
+	if tok.is_punct('(') && is_type_keyword(peek_token()) {
 		var ctype *Ctype
 		read_func_param(&ctype, nil, true)
-		return ast_inttype(ctype_long, ctype.size)
-	}
-	if tok.is_punct('(') {
-		r := get_sizeof_size(true)
 		expect(')')
-		return r
+		if is_incomplete(ctype) {
+			errorf("sizeof applied to an incomplete type: %s", ctype)
+		}
+		return ast_inttype(ctype_ulong, ctype.size)
 	}
 	unget_token(tok)
 	expr := read_unary_expr()
+	if is_incomplete(expr.ctype) {
+		errorf("sizeof applied to an incomplete type: %s", expr.ctype)
+	}
+	if expr.ctype.bitsize > 0 {
+		errorf("sizeof applied to a bit-field: %s", expr)
+	}
 	if expr.ctype.size == 0 {
 		errorf("invalid operand for sizeof(): %s type=%s size=%d", expr, expr.ctype, expr.ctype.size)
 	}
-	return ast_inttype(ctype_long, expr.ctype.size)
+	return ast_inttype(ctype_ulong, expr.ctype.size)
+}
+
+func get_alignof_size() *Ast {
+	tok := read_token()
+	if tok.is_punct('(') && is_type_keyword(peek_token()) {
+		var ctype *Ctype
+		read_func_param(&ctype, nil, true)
+		expect(')')
+		return ast_inttype(ctype_ulong, ctype.align)
+	}
+	unget_token(tok)
+	expr := read_unary_expr()
+	return ast_inttype(ctype_ulong, expr.ctype.align)
+}
+
+func read_cast_type() *Ctype {
+	basetype, _ := read_decl_spec()
+	var name string
+	ctype, _ := read_declarator(&name, basetype, nil, DECL_CAST)
+	expect(')')
+	return ctype
 }
 
 func read_unary_expr() *Ast {
@@ -695,40 +1621,111 @@ func read_unary_expr() *Ast {
 		errorf("premature end of input")
 	}
 	if tok.is_ident("sizeof") {
-		return get_sizeof_size(false)
+		return get_sizeof_size()
+	}
+	if tok.is_ident("_Alignof") || tok.is_ident("__alignof__") {
+		return get_alignof_size()
 	}
 	if tok.typ != TTYPE_PUNCT {
 		unget_token(tok)
-		return read_prim()
+		return read_postfix_expr()
 	}
-	if tok.is_punct('(') {
-		r := read_expr()
-		expect(')')
-		return r
+	if tok.is_punct('(') && is_type_keyword(peek_token()) {
+		ctype := read_cast_type()
+		return ast_cast(ctype, read_unary_expr())
+	}
+	if tok.is_punct(OP_INC) || tok.is_punct(OP_DEC) {
+		operand := read_unary_expr()
+		ensure_assignable(operand)
+		if tok.is_punct(OP_INC) {
+			return ast_inc_dec(OP_PRE_INC, operand)
+		}
+		return ast_inc_dec(OP_PRE_DEC, operand)
 	}
 	if tok.is_punct('&') {
 		operand := read_unary_expr()
-		ensure_lvalue(operand)
+		if operand.typ != AST_FUNCDESG {
+			ensure_lvalue(operand)
+		}
+		if operand.ctype.bitsize > 0 {
+			errorf("cannot take the address of a bit-field: %s", operand)
+		}
 		return ast_uop(AST_ADDR, make_ptr_type(operand.ctype), operand)
 	}
 	if tok.is_punct('-') {
-		expr := read_expr()
+		expr := read_unary_expr()
 		return ast_binop('-', ast_inttype(ctype_int, 0), expr)
 	}
+	if tok.is_punct('+') {
+		expr := read_unary_expr()
+		if !is_inttype(expr.ctype) && !is_flotype(expr.ctype) {
+			errorf("arithmetic type expected, but got %s", expr.ctype)
+		}
+		return ast_binop('+', ast_inttype(ctype_int, 0), expr)
+	}
+	if tok.is_punct('~') {
+		operand := read_unary_expr()
+		if !is_inttype(operand.ctype) {
+			errorf("integer type expected, but got %s", operand.ctype)
+		}
+		return ast_uop(int('~'), promote_int(operand.ctype), operand)
+	}
 	if tok.is_punct('*') {
 		operand := read_unary_expr()
-		ctype := convert_array(operand.ctype) // looks no need to call convert_array.
+		ctype := convert_array(operand.ctype)
 		if ctype.typ != CTYPE_PTR {
-			errorf("pointer type expected, but got %", ctype)
+			errorf("pointer type expected, but got %s", ctype)
 		}
-		return ast_uop(AST_DEREF, operand.ctype.ptr, operand)
+		return ast_uop(AST_DEREF, ctype.ptr, operand)
 	}
 	if tok.is_punct('!') {
 		operand := read_unary_expr()
 		return ast_uop(int('!'), ctype_int, operand)
 	}
 	unget_token(tok)
-	return read_prim()
+	return read_postfix_expr()
+}
+
+func read_postfix_expr() *Ast {
+	ast := read_prim()
+	if ast == nil {
+		return nil
+	}
+	for {
+		tok := read_token()
+		if tok.is_punct('.') {
+			ast = read_struct_field(ast)
+			continue
+		}
+		if tok.is_punct(OP_ARROW) {
+			if ast.ctype.typ != CTYPE_PTR {
+				errorf("pointer type expected, but got %s %s",
+					ast.ctype, ast)
+			}
+			ast = ast_uop(AST_DEREF, ast.ctype.ptr, ast)
+			ast = read_struct_field(ast)
+			continue
+		}
+		if tok.is_punct('[') {
+			ast = read_subscript_expr(ast)
+			continue
+		}
+		if tok.is_punct('(') {
+			ast = read_funcall(ast)
+			continue
+		}
+		if tok.is_punct(OP_INC) || tok.is_punct(OP_DEC) {
+			ensure_assignable(ast)
+			if tok.is_punct(OP_INC) {
+				ast = ast_inc_dec(OP_POST_INC, ast)
+			} else {
+				ast = ast_inc_dec(OP_POST_DEC, ast)
+			}
+			continue
+		}
+		unget_token(tok)
+		return ast
+	}
 }
 
 func read_cond_expr(cond *Ast) *Ast {
@@ -742,12 +1739,37 @@ func read_struct_field(struc *Ast) *Ast {
 	if struc.ctype.typ != CTYPE_STRUCT {
 		errorf("struct expected, but got %s", struc)
 	}
+	if is_incomplete(struc.ctype) {
+		errorf("incomplete type: %s", struc.ctype)
+	}
 	name := read_token()
 	if !name.is_ident_type() {
 		errorf("field name expected, but got %s", name)
 	}
-	field := struc.ctype.fields.GetCtype(name.sval)
-	return ast_struct_ref(field, struc, name.sval)
+	r := find_struct_field(struc, name.sval)
+	if r == nil {
+		errorf("no such field: %s", name)
+	}
+	return r
+}
+
+// find_struct_field looks up a field by name, descending into the
+// anonymous members of the struct.
+func find_struct_field(struc *Ast, name string) *Ast {
+	fields := struc.ctype.fields
+	if field := fields.GetCtype(name); field != nil {
+		return ast_struct_ref(field, struc, name)
+	}
+	keys := fields.Keys()
+	for i, v := range fields.Values() {
+		if keys[i] != "" || v.ctype.typ != CTYPE_STRUCT {
+			continue
+		}
+		if r := find_struct_field(ast_struct_ref(v.ctype, struc, ""), name); r != nil {
+			return r
+		}
+	}
+	return nil
 }
 
 func read_expr_int(prec int) *Ast {
@@ -774,31 +1796,8 @@ func read_expr_int(prec int) *Ast {
 			ast = read_cond_expr(ast)
 			continue
 		}
-		if tok.is_punct('.') {
-			ast = read_struct_field(ast)
-			continue
-		}
-		if tok.is_punct(OP_ARROW) {
-			if ast.ctype.typ != CTYPE_PTR {
-				errorf("pointer type expected, but got %s %s",
-					ast.ctype, ast)
-			}
-			ast = ast_uop(AST_DEREF, ast.ctype.ptr, ast)
-			ast = read_struct_field(ast)
-			continue
-		}
-		if tok.is_punct('[') {
-			ast = read_subscript_expr(ast)
-			continue
-		}
-		// This is BUG?
-		if tok.is_punct(OP_INC) || tok.is_punct(OP_DEC) {
-			ensure_lvalue(ast)
-			ast = ast_uop(tok.punct, ast.ctype, ast)
-			continue
-		}
-		if tok.is_punct('=') {
-			ensure_lvalue(ast)
+		if tok.is_punct('=') || is_assign_op(tok.punct) {
+			ensure_assignable(ast)
 		}
 		var prec_incr int
 		if is_right_assoc(tok) {
@@ -810,79 +1809,282 @@ func read_expr_int(prec int) *Ast {
 		if rest == nil {
 			errorf("second operand missing")
 		}
-		ast = ast_binop(tok.punct, ast, rest)
+		if is_assign_op(tok.punct) {
+			ast = ast_assign_op(tok.punct, ast, rest)
+		} else {
+			ast = ast_binop(tok.punct, ast, rest)
+		}
+
+	}
+	return ast
+}
 
+func read_expr() *Ast {
+	return read_expr_int(MAX_OP_PRIO)
+}
+
+func is_type_keyword(tok *Token) bool {
+	if !tok.is_ident_type() {
+		return false
+	}
+
+	keyword := []string{
+		"char", "short", "int", "long", "float", "double", "struct",
+		"union", "signed", "unsigned", "enum", "void", "typedef", "extern",
+		"static", "auto", "register", "const", "volatile", "inline",
+		"_Alignas",
+	}
+	for _, k := range keyword {
+		if k == tok.sval {
+			return true
+		}
+	}
+
+	return get_typedef(tok.sval) != nil
+}
+
+// init_pending is an expression read ahead to see if it initializes
+// a whole struct. If it does not, it initializes the first scalar of the
+// struct, whose braces are omitted.
+var init_pending *Ast
+
+func read_init_expr() *Ast {
+	if init_pending != nil {
+		r := init_pending
+		init_pending = nil
+		return r
+	}
+	tok := peek_token()
+	r := read_expr()
+	if r == nil {
+		errorf("expression expected, but got %s", tok)
+	}
+	return r
+}
+
+func skip_init_comma() {
+	tok := read_token()
+	if !tok.is_punct(',') {
+		unget_token(tok)
 	}
-	return ast
 }
 
-func read_expr() *Ast {
-	return read_expr_int(MAX_OP_PRIO)
+// is_string_array returns true if ctype is an array that may be
+// initialized with a string literal in the encoding.
+func is_string_array(ctype *Ctype, enc int) bool {
+	return ctype.typ == CTYPE_ARRAY && is_inttype(ctype.ptr) && ctype.ptr.size == enc_type(enc).size
 }
 
-func is_type_keyword(tok *Token) bool {
-	if !tok.is_ident_type() {
-		return false
+// read_init_elem reads the initializer of an object of type ctype at
+// offset off and appends the initializers of its scalars to inits.
+// designated is true if the object is reached by a designator, which
+// may continue with the designators of its members.
+func read_init_elem(inits []*Ast, ctype *Ctype, off int, designated bool) []*Ast {
+	if designated && peek_token().is_punct('=') {
+		read_token()
 	}
-
-	keyword := []string{
-		"char", "short", "int", "long", "float", "double", "struct",
-		"union", "signed", "unsigned", "enum", "void", "typedef", "extern",
-		"static", "auto", "register", "const", "volatile", "inline",
+	tok := peek_token()
+	if ctype.typ == CTYPE_STRUCT && init_pending == nil && !tok.is_punct('{') &&
+		!(designated && (tok.is_punct('.') || tok.is_punct('['))) {
+		expr := read_init_expr()
+		if expr.ctype.typ == CTYPE_STRUCT {
+			result_type('=', ctype, expr.ctype)
+			return append(inits, ast_init(expr, ctype, off))
+		}
+		init_pending = expr
+	}
+	if ctype.typ == CTYPE_ARRAY || ctype.typ == CTYPE_STRUCT {
+		return read_init_list(inits, ctype, off, designated)
+	}
+	if init_pending == nil && tok.is_punct('{') {
+		// A scalar in braces
+		read_token()
+		inits = read_init_elem(inits, ctype, off, false)
+		skip_init_comma()
+		expect('}')
+		return inits
+	}
+	expr := read_init_expr()
+	result_type('=', expr.ctype, ctype)
+	return append(inits, ast_init(expr, ctype, off))
+}
+
+// read_init_list reads the initializer of an array or a struct at
+// offset off. Without braces, only as many initializers as its members
+// are read from the list of the enclosing object.
+func read_init_list(inits []*Ast, ctype *Ctype, off int, designated bool) []*Ast {
+	if ctype.typ == CTYPE_ARRAY && is_inttype(ctype.ptr) {
+		if init_pending != nil && init_pending.typ == AST_STRING && is_string_array(ctype, init_pending.enc) {
+			s := init_pending
+			init_pending = nil
+			return read_string_init(inits, ctype, s.chars, off)
+		}
+		tok := read_token()
+		if tok.typ == TTYPE_STRING && is_string_array(ctype, tok.enc) {
+			return read_string_init(inits, ctype, tok.chars, off)
+		}
+		if tok.is_punct('{') && peek_token().typ == TTYPE_STRING && is_string_array(ctype, peek_token().enc) {
+			s := read_token()
+			inits = read_string_init(inits, ctype, s.chars, off)
+			skip_init_comma()
+			expect('}')
+			return inits
+		}
+		unget_token(tok)
 	}
-	for _, k := range keyword {
-		if k == tok.sval {
-			return true
+	has_brace := false
+	if init_pending == nil {
+		tok := read_token()
+		has_brace = tok.is_punct('{')
+		if !has_brace {
+			unget_token(tok)
 		}
 	}
+	if ctype.typ == CTYPE_ARRAY {
+		inits = read_array_init(inits, ctype, off, has_brace, designated)
+	} else {
+		inits = read_struct_init(inits, ctype, off, has_brace, designated)
+	}
+	if has_brace {
+		tok := read_token()
+		if !tok.is_punct('}') {
+			errorf("excess elements in initializer: %s", tok)
+		}
+	}
+	return inits
+}
 
-	return typedefs.GetCtype(tok.sval) != nil
+func read_string_init(inits []*Ast, ctype *Ctype, chars []int, off int) []*Ast {
+	if ctype.len == -1 {
+		ctype.len = len(chars) + 1
+		ctype.size = ctype.len * ctype.ptr.size
+	}
+	// The terminating NUL is dropped if the array has no room for it.
+	for i := 0; i <= len(chars) && i < ctype.len; i++ {
+		c := 0
+		if i < len(chars) {
+			c = chars[i]
+		}
+		inits = append(inits, ast_init(ast_inttype(ctype.ptr, c), ctype.ptr, off+i*ctype.ptr.size))
+	}
+	return inits
 }
 
-func read_decl_init_elem(initlist []*Ast, ctype *Ctype) []*Ast {
-	tok := peek_token()
-	init := read_expr()
-	if init == nil {
-		errorf("expression expected, but got %s", tok)
+// read_designator_start reads the next token of an initializer list.
+// It returns nil at the end of the list, and at a designator that
+// belongs to an enclosing list.
+func read_designator_start(has_brace bool, designated bool) *Token {
+	tok := read_token()
+	if tok.is_punct('}') {
+		unget_token(tok)
+		return nil
 	}
-	initlist = append(initlist, init)
-	result_type('=', init.ctype, ctype)
-	init.totype = ctype
-	tok = read_token()
-	if !tok.is_punct(',') {
+	if (tok.is_punct('.') || tok.is_punct('[')) && !has_brace && !designated {
 		unget_token(tok)
+		return nil
 	}
-	return initlist
+	return tok
 }
 
-func read_decl_array_init_int(initlist []*Ast, ctype *Ctype) []*Ast {
-	tok := read_token()
-	assert(ctype.typ == CTYPE_ARRAY)
-	if ctype.ptr.typ == CTYPE_CHAR && tok.typ == TTYPE_STRING {
-		for _,p := range tok.sval {
-			c := ast_inttype(ctype_char, int(p))
-			c.totype = ctype_char
-			initlist = append(initlist, c)
+func read_array_init(inits []*Ast, ctype *Ctype, off int, has_brace bool, designated bool) []*Ast {
+	flexible := ctype.len == -1
+	elemsize := ctype.ptr.size
+	length := 0
+	for i := 0; ; i++ {
+		if init_pending == nil {
+			tok := read_designator_start(has_brace, designated)
+			if tok == nil {
+				break
+			}
+			if tok.is_punct('[') {
+				i = eval_intexpr(read_expr())
+				if i < 0 || (!flexible && ctype.len <= i) {
+					errorf("array designator exceeds array bounds: %d", i)
+				}
+				expect(']')
+				designated = true
+			} else {
+				unget_token(tok)
+			}
+		}
+		if !flexible && ctype.len <= i {
+			break
 		}
-		c := ast_inttype(ctype_char, 0)
-		c.totype = ctype_char
-		initlist = append(initlist, c)
-		return initlist
+		inits = read_init_elem(inits, ctype.ptr, off+elemsize*i, designated)
+		skip_init_comma()
+		designated = false
+		if length < i+1 {
+			length = i + 1
+		}
+	}
+	if flexible {
+		ctype.len = length
+		ctype.size = elemsize * length
 	}
+	return inits
+}
 
-	if !tok.is_punct('{') {
-		errorf("Expected an initializer list, but got %s", tok)
+// find_field_index returns the index of the member of a struct that is
+// or contains the named field, or -1 if there is none.
+func find_field_index(ctype *Ctype, name string) int {
+	keys := ctype.fields.Keys()
+	for i, v := range ctype.fields.Values() {
+		if keys[i] == name {
+			return i
+		}
+		if keys[i] == "" && v.ctype.typ == CTYPE_STRUCT && find_field_index(v.ctype, name) >= 0 {
+			return i
+		}
 	}
+	return -1
+}
+
+func read_struct_init(inits []*Ast, ctype *Ctype, off int, has_brace bool, designated bool) []*Ast {
+	keys := ctype.fields.Keys()
+	values := ctype.fields.Values()
+	i := 0
 	for {
-		tok := read_token()
-		if tok.is_punct('}') {
+		if init_pending == nil {
+			tok := read_designator_start(has_brace, designated)
+			if tok == nil {
+				break
+			}
+			if tok.is_punct('.') {
+				name := read_token()
+				if !name.is_ident_type() {
+					errorf("field name expected, but got %s", name)
+				}
+				i = find_field_index(ctype, name.sval)
+				if i < 0 {
+					errorf("no such field: %s", name)
+				}
+				if keys[i] == "" {
+					// The field is in an anonymous member.
+					unget_token(name)
+					unget_token(tok)
+				}
+				designated = true
+			} else {
+				unget_token(tok)
+			}
+		}
+		// Unnamed bit-fields are not initialized.
+		for i < len(keys) && keys[i] == "" && values[i].ctype.bitsize >= 0 {
+			i++
+		}
+		if i == len(keys) {
+			break
+		}
+		fieldtype := values[i].ctype
+		inits = read_init_elem(inits, fieldtype, off+fieldtype.offset, designated)
+		skip_init_comma()
+		designated = false
+		i++
+		if ctype.isunion {
 			break
 		}
-		unget_token(tok)
-		initlist = read_decl_init_elem(initlist, ctype.ptr)
 	}
-
-	return initlist
+	return inits
 }
 
 func read_struct_union_tag() string {
@@ -906,10 +2108,36 @@ func read_struct_union_fields() *Dict {
 		if !is_type_keyword(peek_token()) {
 			break
 		}
+		// An untagged struct or union without a declarator is
+		// an anonymous member, whose members belong to the outer one.
+		tok = read_token()
+		untagged := (tok.is_ident("struct") || tok.is_ident("union")) && peek_token().is_punct('{')
+		unget_token(tok)
 		basetype, _ := read_decl_spec()
+		if peek_token().is_punct(';') {
+			// Without a declarator, a tagged struct or union
+			// declares only its tag.
+			read_token()
+			if untagged {
+				fieldtype := copy_type(basetype)
+				fieldtype.bitsize = -1
+				r.PutCtype("", fieldtype)
+			}
+			continue
+		}
 		for {
 			var name string
-			fieldtype,_ := read_declarator(&name, basetype, nil, DECL_PARAM)
+			fieldtype,_ := read_declarator(&name, basetype, nil, DECL_PARAM_TYPEONLY)
+			// Each member has its own offset.
+			fieldtype = copy_type(fieldtype)
+			fieldtype.bitoff = 0
+			fieldtype.bitsize = read_bitsize(name, fieldtype)
+			if name == "" && fieldtype.bitsize < 0 {
+				errorf("field name expected, but got %s", peek_token())
+			}
+			if is_incomplete(fieldtype) {
+				errorf("field has incomplete type: %s", name)
+			}
 			r.PutCtype(name, fieldtype)
 			tok = read_token()
 			if tok.is_punct(',') {
@@ -924,77 +2152,157 @@ func read_struct_union_fields() *Dict {
 	return r
 }
 
-func compute_union_size(fields *Dict) int {
+// read_bitsize reads the width of a bit-field, or returns -1 if the
+// member is not a bit-field.
+func read_bitsize(name string, ctype *Ctype) int {
+	tok := read_token()
+	if !tok.is_punct(':') {
+		unget_token(tok)
+		return -1
+	}
+	if !is_inttype(ctype) {
+		errorf("non-integer type cannot be a bit-field: %s", ctype)
+	}
+	r := eval_intexpr(read_expr())
+	if r < 0 || ctype.size*8 < r {
+		errorf("invalid bit-field width for %s: %d", ctype, r)
+	}
+	if r == 0 && name != "" {
+		errorf("zero-width bit-field needs to be unnamed: %s", name)
+	}
+	return r
+}
+
+// compute_union_size returns the size and the alignment of a union.
+func compute_union_size(fields *Dict) (int, int) {
 	maxsize := 0
-	for _, v := range fields.Values() {
+	maxalign := 1
+	keys := fields.Keys()
+	for i, v := range fields.Values() {
 		fieldtype := v.ctype
+		fieldtype.offset = 0
 		if maxsize < fieldtype.size {
 			maxsize = fieldtype.size
 		}
+		unnamed_bitfield := keys[i] == "" && fieldtype.bitsize >= 0
+		if !unnamed_bitfield && maxalign < fieldtype.align {
+			maxalign = fieldtype.align
+		}
 	}
-	return maxsize
+	return align(maxsize, maxalign), maxalign
 }
 
-func compute_struct_size(fields *Dict) int {
-	offset := 0
-	for _, v := range fields.Values() {
+// compute_struct_size lays out the members of a struct like gcc does,
+// and returns the size and the alignment of the struct. A bit-field is
+// placed right after the previous member unless it would cross a
+// boundary of its type's alignment, and it is accessed through the
+// aligned unit of its type that contains it. Unnamed bit-fields do not
+// affect the alignment of the struct.
+func compute_struct_size(fields *Dict) (int, int) {
+	// The current position in bits.
+	bitpos := 0
+	maxalign := 1
+	keys := fields.Keys()
+	for i, v := range fields.Values() {
 		fieldtype := v.ctype
-		var align int
-		if fieldtype.size < MAX_ALIGN {
-			align = fieldtype.size
-		} else {
-			align = MAX_ALIGN
+		bits := fieldtype.align * 8
+		switch {
+		case fieldtype.bitsize == 0:
+			bitpos = align(bitpos, bits)
+			fieldtype.offset = bitpos / 8
+			continue
+		case fieldtype.bitsize > 0:
+			if bitpos/bits != (bitpos+fieldtype.bitsize-1)/bits {
+				bitpos = align(bitpos, bits)
+			}
+			fieldtype.offset = bitpos / bits * fieldtype.align
+			fieldtype.bitoff = bitpos - fieldtype.offset*8
+			bitpos += fieldtype.bitsize
+			if keys[i] == "" {
+				continue
+			}
+		default:
+			offset := align(align(bitpos, 8)/8, fieldtype.align)
+			fieldtype.offset = offset
+			bitpos = (offset + fieldtype.size) * 8
 		}
-		if offset%align != 0 {
-			offset += align - offset%align
+		if maxalign < fieldtype.align {
+			maxalign = fieldtype.align
 		}
-		fieldtype.offset = offset
-		offset += fieldtype.size
 	}
-	return offset
+	return align(align(bitpos, 8)/8, maxalign), maxalign
 }
 
-func read_struct_union_def(env *Dict, compute_size func(*Dict)int) *Ctype {
+// read_struct_union_def reads a struct or union specifier. A tag
+// without members refers to the type of the tag in the innermost scope,
+// or declares an incomplete type if there is none. A definition, or a
+// declaration "struct tag;", declares the tag in the current scope.
+// An incomplete type is completed in place, so that the types derived
+// from it before the definition see the members.
+func read_struct_union_def(isunion bool, compute_size func(*Dict) (int, int)) *Ctype {
 	tag := read_struct_union_tag()
-	var prev *Ctype
+	var r *Ctype
 	if tag != "" {
-		prev = env.GetCtype(tag)
-	} else {
-		prev = nil
+		next := peek_token()
+		if next.is_punct('{') || next.is_punct(';') {
+			if v := tagenv.GetLocal(tag); v != nil {
+				r = v.ctype
+			}
+		} else {
+			r = tagenv.GetCtype(tag)
+		}
 	}
-	fields := read_struct_union_fields()
-	if prev != nil {
-		return prev
+	if r == nil {
+		r = make_struct_type(nil, 0, 1)
+		r.isunion = isunion
+		if tag != "" {
+			tagenv.PutCtype(tag, r)
+		}
 	}
-	var r *Ctype
-	if fields != nil {
-		r = make_struct_type(fields, compute_size(fields))
-	} else {
-		r = make_struct_type(nil, 0)
+	if r.typ != CTYPE_STRUCT || r.isunion != isunion {
+		errorf("%s is not a %s tag", tag, choose(isunion, "union", "struct"))
 	}
-	if tag != "" {
-		env.PutCtype(tag, r)
+	fields := read_struct_union_fields()
+	if fields != nil {
+		if r.fields != nil {
+			errorf("redefinition of %s", tag)
+		}
+		r.fields = fields
+		r.size, r.align = compute_size(fields)
 	}
 	return r
 }
 
 func read_struct_def() *Ctype {
-	return read_struct_union_def(&struct_defs, compute_struct_size)
+	return read_struct_union_def(false, compute_struct_size)
 }
 
 func read_union_def() *Ctype {
-	return read_struct_union_def(&union_defs, compute_union_size)
+	return read_struct_union_def(true, compute_union_size)
 }
 
 func read_enum_def() *Ctype {
 	tok := read_token()
+	tag := ""
 	if tok.is_ident_type() {
+		tag = tok.sval
 		tok = read_token()
 	}
 	if !tok.is_punct('{') {
 		unget_token(tok)
+		if tag != "" {
+			if prev := tagenv.GetCtype(tag); prev != nil && prev.typ != CTYPE_INT {
+				errorf("%s is not an enum tag", tag)
+			}
+		}
 		return ctype_int
 	}
+	if tag != "" {
+		if prev := tagenv.GetLocal(tag); prev != nil {
+			errorf("redefinition of %s", tag)
+		}
+		tagenv.PutCtype(tag, ctype_int)
+	}
 	val := 0
 	for {
 		tok = read_token()
@@ -1015,11 +2323,7 @@ func read_enum_def() *Ctype {
 
 		constval := ast_inttype(ctype_int, val)
 		val++
-		if localenv != nil {
-			localenv.PutAst(name, constval)
-		} else {
-			globalenv.PutAst(name, constval)
-		}
+		env().PutAst(name, constval)
 		tok = read_token()
 		if tok.is_punct(',') {
 			continue
@@ -1065,14 +2369,21 @@ func read_direct_declarator2(basetype *Ctype, params []*Ast) (*Ctype, []*Ast) {
 	return basetype, params
 }
 
-func skip_type_qualifiers() {
+// read_type_qualifiers reads the qualifiers after a '*' and returns
+// true if const is among them.
+func read_type_qualifiers() bool {
+	isconst := false
 	for {
 		tok := read_token()
-		if tok.is_ident("const") || tok.is_ident("volatiles") {
+		if tok.is_ident("const") {
+			isconst = true
+			continue
+		}
+		if tok.is_ident("volatile") || tok.is_ident("restrict") {
 			continue
 		}
 		unget_token(tok)
-		return
+		return isconst
 	}
 }
 
@@ -1088,10 +2399,11 @@ func read_direct_declarator1(rname *string, basetype *Ctype, params []*Ast, ctx
 		return t, params
 	}
 	if tok.is_punct('*') {
-		skip_type_qualifiers()
+		isconst := read_type_qualifiers()
 		stub := make_stub_type()
 		t, params := read_direct_declarator1(rname, stub, params, ctx)
 		*stub = *make_ptr_type(basetype)
+		stub.isconst = isconst
 		return t, params
 	}
 
@@ -1115,6 +2427,7 @@ func fix_array_size(t *Ctype) {
 	if t.typ == CTYPE_ARRAY {
 		fix_array_size(t.ptr)
 		t.size = t.len * t.ptr.size
+		t.align = t.ptr.align
 	} else if t.typ == CTYPE_PTR {
 		fix_array_size(t.ptr)
 	} else if t.typ == CTYPE_FUNC {
@@ -1128,12 +2441,9 @@ func read_declarator(rname *string, basetype *Ctype, params []*Ast, ctx int) (*C
 	return t, params
 }
 
-var kconst int
-var kvolatile int
-var kinline int
-
 func read_decl_spec() (*Ctype, int) {
 	var sclass int
+	isconst := false
 
 	tok := peek_token()
 	if tok == nil || tok.typ != TTYPE_IDENT {
@@ -1165,6 +2475,7 @@ func read_decl_spec() (*Ctype, int) {
 	)
 	var typ ttype
 	var size ttype
+	alignas := 0
 
 	myerror := func (tok *Token) {
 		errorf("internal error")
@@ -1228,13 +2539,9 @@ func read_decl_spec() (*Ctype, int) {
 		} else if s == "register" {
 			setsclass(S_REGISTER)
 		} else if s == "const" {
-			kconst = 1
-		} else if s == "volatile" {
-			kvolatile = 1
-		} else if s == "inline" {
-			kinline = 1
-		} else if s == "static" {
-			// ignore
+			isconst = true
+		} else if s == "volatile" || s == "inline" {
+			// no effect on the generated code
 		} else if s == "void" {
 			setType(kvoid)
 		} else if s == "char" {
@@ -1251,6 +2558,10 @@ func read_decl_spec() (*Ctype, int) {
 			setSig(kunsigned)
 		} else if s == "short" {
 			setSize(kshort)
+		} else if s == "_Alignas" {
+			if a := read_alignas(); alignas < a {
+				alignas = a
+			}
 		} else if s == "struct" {
 			setUserType(read_struct_def())
 		} else if s == "union" {
@@ -1265,7 +2576,9 @@ func read_decl_spec() (*Ctype, int) {
 			} else {
 				myerror(tok)
 			}
-		} else if tmp = typedefs.GetCtype(s); tmp != nil {
+		} else if tmp = get_typedef(s); tmp != nil && typ == 0 && size == 0 && sig == 0 && usertype == nil {
+			// A typedef name after another type specifier is
+			// a declarator that may shadow the typedef.
 			setUserType(tmp)
 		} else {
 			unget_token(tok)
@@ -1274,34 +2587,56 @@ func read_decl_spec() (*Ctype, int) {
 		setsclass = nil
 	}
 
-	if usertype != nil {
-		return usertype, sclass
+	var r *Ctype
+	switch {
+	case usertype != nil:
+		r = usertype
+	case typ == kchar:
+		r = make_type(CTYPE_CHAR, sig != kunsigned)
+	case typ == kfloat:
+		r = make_type(CTYPE_FLOAT, false)
+	case typ == kdouble && size == klong:
+		r = make_type(CTYPE_LDOUBLE, false)
+	case typ == kdouble:
+		r = make_type(CTYPE_DOUBLE, false)
+	case size == kshort:
+		r = make_type(CTYPE_SHORT, sig != kunsigned)
+	case size == klong:
+		r = make_type(CTYPE_LONG, sig != kunsigned)
+	case size == kllong:
+		r = make_type(CTYPE_LLONG, sig != kunsigned)
+	default:
+		r = make_type(CTYPE_INT, sig != kunsigned )
 	}
-	switch typ {
-	case kchar:
-		return make_type(CTYPE_CHAR, sig != kunsigned), sclass
-	case kfloat:
-		return make_type(CTYPE_FLOAT, false), sclass
-	case kdouble:
-		var ctyp int
-		if size == klong {
-			ctyp = CTYPE_LDOUBLE
-		} else {
-			ctyp = CTYPE_DOUBLE
-		}
-		return make_type(ctyp, false), sclass
+	if alignas > r.align {
+		r = copy_type(r)
+		r.align = alignas
 	}
-	switch size {
-	case kshort:
-		return make_type(CTYPE_SHORT, sig != kunsigned), sclass
-	case klong:
-		return make_type(CTYPE_LONG, sig != kunsigned), sclass
-	case kllong:
-		return make_type(CTYPE_LLONG, sig != kunsigned), sclass
-	default:
-		return make_type(CTYPE_INT, sig != kunsigned ), sclass
+	// An incomplete struct is not copied, as it is completed in place.
+	if isconst && !r.isconst && !is_incomplete(r) {
+		r = copy_type(r)
+		r.isconst = true
+	}
+	return r, sclass
+}
+
+// read_alignas reads the operand of _Alignas, which is a type or
+// a constant expression, and returns the alignment.
+func read_alignas() int {
+	expect('(')
+	var r int
+	if is_type_keyword(peek_token()) {
+		var ctype *Ctype
+		read_func_param(&ctype, nil, true)
+		r = ctype.align
+	} else {
+		r = eval_intexpr(read_expr())
 	}
-	return nil, 0
+	expect(')')
+	if r < 0 || r&(r-1) != 0 {
+		errorf("alignment must be a power of 2, but got %d", r)
+	}
+	return r
 }
 
 func read_func_param(rtype **Ctype, name *string, optional bool) {
@@ -1316,61 +2651,25 @@ func read_func_param(rtype **Ctype, name *string, optional bool) {
 	*rtype = read_array_dimensions(basetype)
 }
 
-func read_decl_array_init_val(ctype *Ctype) *Ast {
-	var initlist []*Ast
-	initlist = read_decl_array_init_int(initlist, ctype)
-	init := ast_init_list(initlist)
-
-	var length int
-	if init.typ == AST_STRING {
-		length = len(init.val) + 1
-	} else {
-		length = len(init.initlist)
-	}
-	if ctype.len == -1 {
-		ctype.len = length
-		ctype.size = length * ctype.ptr.size
-	} else if ctype.len != length {
-		errorf("Invalid array initializer: expected %d items but got %d",
-			ctype.len, length)
-	}
-	return init
-}
-
-func read_decl_struct_init_val(ctype *Ctype) *Ast {
-	expect('{')
-	var initlist []*Ast
-	for _, val := range ctype.fields.Values() {
-		fieldtype := val.ctype
-		tok := read_token()
-		if tok.is_punct('}') {
-			return ast_init_list(initlist)
-		}
-		if tok.is_punct('{') {
-			if fieldtype.typ != CTYPE_ARRAY {
-				errorf("array expected, but got %s", fieldtype)
-			}
-			unget_token(tok)
-			initlist = read_decl_array_init_int(initlist, fieldtype)
-			continue
-		}
-		unget_token(tok)
-		initlist= read_decl_init_elem(initlist, fieldtype)
-	}
-	expect('}')
-	return ast_init_list(initlist)
-}
-
+// read_decl_init_val reads the initializer of a variable. An initializer
+// list is flattened into the initializers of the scalars of the
+// variable, each with the offset of the scalar in initoff and its type
+// in totype, sorted by the offset. The scalars without an initializer
+// are zero.
 func read_decl_init_val(ctype *Ctype) *Ast {
-	var init *Ast
-	if ctype.typ == CTYPE_ARRAY {
-		init = read_decl_array_init_val(ctype)
-	} else if ctype.typ == CTYPE_STRUCT {
-		init = read_decl_struct_init_val(ctype)
-	} else {
-		init = read_expr()
+	tok := peek_token()
+	if ctype.typ != CTYPE_ARRAY && !tok.is_punct('{') {
+		return read_expr()
+	}
+	if ctype.typ == CTYPE_ARRAY && !tok.is_punct('{') && !(tok.typ == TTYPE_STRING && is_string_array(ctype, tok.enc)) {
+		errorf("initializer list expected, but got %s", tok)
 	}
-	return init
+	var inits []*Ast
+	inits = read_init_elem(inits, ctype, 0, false)
+	sort.SliceStable(inits, func(i, j int) bool {
+		return inits[i].initoff < inits[j].initoff
+	})
+	return ast_init_list(inits)
 }
 
 func read_array_dimensions_int(basetype *Ctype) *Ctype {
@@ -1405,9 +2704,21 @@ func read_array_dimensions(basetype *Ctype) *Ctype {
 }
 
 func read_decl_init(variable *Ast) *Ast {
+	if variable.ctype.typ == CTYPE_ARRAY && variable.ctype.len == -1 {
+		// The length is taken from the initializer.
+		variable.ctype = copy_type(variable.ctype)
+	}
 	init := read_decl_init_val(variable.ctype)
-	if variable.typ == AST_GVAR && is_inttype(variable.ctype) {
-		init = ast_inttype(ctype_int, eval_intexpr(init))
+	if init.typ != AST_INIT_LIST {
+		result_type('=', variable.ctype, init.ctype)
+	}
+	if variable.typ == AST_GVAR && init.typ == AST_INIT_LIST {
+		for i, v := range init.initlist {
+			val := eval_static_init(v.operand, v.totype)
+			init.initlist[i] = ast_init(val, v.totype, v.initoff)
+		}
+	} else if variable.typ == AST_GVAR {
+		init = eval_static_init(init, variable.ctype)
 	}
 	return ast_decl(variable, init)
 }
@@ -1452,20 +2763,140 @@ func read_opt_expr() *Ast {
 func read_for_stmt() *Ast {
 	expect('(')
 	localenv = MakeDict(localenv)
+	tagenv = MakeDict(tagenv)
 	init := read_opt_decl_or_stmt()
 	cond := read_opt_expr()
 	var step *Ast
 	if peek_token().is_punct(')') {
 		step = nil
 	} else {
-		step = read_expr()
+		step = ast_discard(read_expr())
 	}
 	expect(')')
 	body := read_stmt()
 	localenv = localenv.Parent()
+	tagenv = tagenv.Parent()
 	return ast_for(init, cond, step, body)
 }
 
+func read_while_stmt() *Ast {
+	expect('(')
+	cond := read_expr()
+	expect(')')
+	body := read_stmt()
+	return ast_while(cond, body)
+}
+
+func read_do_stmt() *Ast {
+	body := read_stmt()
+	tok := read_token()
+	if !tok.is_ident("while") {
+		errorf("'while' expected, but got %s", tok)
+	}
+	expect('(')
+	cond := read_expr()
+	expect(')')
+	expect(';')
+	return ast_do(cond, body)
+}
+
+func read_break_stmt() *Ast {
+	expect(';')
+	return ast_jump(AST_BREAK)
+}
+
+func read_continue_stmt() *Ast {
+	expect(';')
+	return ast_jump(AST_CONTINUE)
+}
+
+func read_switch_stmt() *Ast {
+	expect('(')
+	cond := read_expr()
+	if !is_inttype(cond.ctype) {
+		errorf("integer type expected, but got %s", cond.ctype)
+	}
+	expect(')')
+	r := ast_switch(cond)
+	prev := current_switch
+	current_switch = r
+	r.body = read_stmt()
+	current_switch = prev
+	return r
+}
+
+// switch_value converts a case value to the promoted type
+// of the controlling expression of the current switch statement.
+func switch_value(val int) int {
+	return cast_intval(promote_int(current_switch.cond.ctype), val)
+}
+
+func read_case_stmt() *Ast {
+	if current_switch == nil {
+		errorf("stray case label")
+	}
+	val := switch_value(eval_intexpr(read_expr()))
+	expect(':')
+	for _, c := range current_switch.cases {
+		if c.ival == val {
+			errorf("duplicate case value: %d", val)
+		}
+	}
+	r := ast_case(AST_CASE, val)
+	current_switch.cases = append(current_switch.cases, r)
+	r.body = read_stmt()
+	return r
+}
+
+func read_default_stmt() *Ast {
+	if current_switch == nil {
+		errorf("stray default label")
+	}
+	expect(':')
+	if current_switch.defcase != nil {
+		errorf("duplicate default label")
+	}
+	r := ast_case(AST_DEFAULT, 0)
+	current_switch.defcase = r
+	r.body = read_stmt()
+	return r
+}
+
+func read_goto_stmt() *Ast {
+	tok := read_token()
+	if tok == nil || !tok.is_ident_type() {
+		errorf("identifier expected, but got %s", tok)
+	}
+	expect(';')
+	r := ast_goto(tok.sval)
+	gotos = append(gotos, r)
+	return r
+}
+
+func read_label(tok *Token) *Ast {
+	expect(':')
+	if labels.GetAst(tok.sval) != nil {
+		errorf("duplicate label: %s", tok.sval)
+	}
+	r := ast_label(tok.sval)
+	labels.PutAst(tok.sval, r)
+	r.body = read_stmt()
+	return r
+}
+
+// resolve_gotos binds every goto statement in the current function
+// to its label. Labels have function scope, so this can only be done
+// after the whole function body has been read.
+func resolve_gotos() {
+	for _, v := range gotos {
+		label := labels.GetAst(v.labelname)
+		if label == nil {
+			errorf("undefined label: %s", v.labelname)
+		}
+		v.label = label.label
+	}
+}
+
 func read_return_stmt() *Ast {
 	retval := read_expr()
 	expect(';')
@@ -1480,18 +2911,55 @@ func read_stmt() *Ast {
 	if tok.is_ident("for") {
 		return read_for_stmt()
 	}
+	if tok.is_ident("while") {
+		return read_while_stmt()
+	}
+	if tok.is_ident("do") {
+		return read_do_stmt()
+	}
+	if tok.is_ident("break") {
+		return read_break_stmt()
+	}
+	if tok.is_ident("continue") {
+		return read_continue_stmt()
+	}
+	if tok.is_ident("switch") {
+		return read_switch_stmt()
+	}
+	if tok.is_ident("case") {
+		return read_case_stmt()
+	}
+	if tok.is_ident("default") {
+		return read_default_stmt()
+	}
+	if tok.is_ident("goto") {
+		return read_goto_stmt()
+	}
 	if tok.is_ident("return") {
 		return read_return_stmt()
 	}
 	if tok.is_punct('{') {
 		return read_compound_stmt()
 	}
+	if tok.is_ident_type() && peek_token().is_punct(':') {
+		return read_label(tok)
+	}
 	unget_token(tok)
-	r := read_expr()
+	r := ast_discard(read_expr())
 	expect(';')
 	return r
 }
 
+// ast_discard marks the value of an expression statement as unused.
+// A long double is left on the x87 register stack, so it is cast to
+// void to be popped.
+func ast_discard(ast *Ast) *Ast {
+	if ast != nil && ast.ctype != nil && ast.ctype.typ == CTYPE_LDOUBLE {
+		return ast_uop(OP_CAST, ctype_void, ast)
+	}
+	return ast
+}
+
 func read_decl_or_stmt(list *[]*Ast) {
 	tok := peek_token()
 	if tok == nil {
@@ -1506,17 +2974,19 @@ func read_decl_or_stmt(list *[]*Ast) {
 
 func read_compound_stmt() *Ast {
 	localenv = MakeDict(localenv)
+	tagenv = MakeDict(tagenv)
 	var list []*Ast
 
 	for {
-		read_decl_or_stmt(&list)
 		tok := read_token()
 		if tok.is_punct('}') {
 			break
 		}
 		unget_token(tok)
+		read_decl_or_stmt(&list)
 	}
 	localenv = localenv.Parent()
+	tagenv = tagenv.Parent()
 	return ast_compound_stmt(list)
 }
 
@@ -1526,13 +2996,20 @@ func read_func_param_list(rettype *Ctype, paramvars []*Ast) (*Ctype, []*Ast) {
 	var rtype *Ctype
 	pt := read_token()
 	if pt.is_punct(')') {
+		// An empty parameter list does not specify the parameters.
 		rtype = make_func_type(rettype, paramtypes, false)
+		rtype.oldstyle = true
 		return rtype, nil
 	}
+	if pt.is_ident("void") && peek_token().is_punct(')') {
+		read_token()
+		rtype = make_func_type(rettype, paramtypes, false)
+		return rtype, paramvars
+	}
 	unget_token(pt)
 	for {
 		pt = read_token()
-		if pt.is_ident("...") {
+		if pt.is_punct(OP_ELLIPSIS) {
 			if len(paramtypes) == 0 {
 				errorf("at least one parameter is required")
 			}
@@ -1545,9 +3022,7 @@ func read_func_param_list(rettype *Ctype, paramvars []*Ast) (*Ctype, []*Ast) {
 		var ptype *Ctype
 		var name string
 		read_func_param(&ptype, &name, typeonly)
-		if ptype.typ == CTYPE_ARRAY {
-			ptype = make_ptr_type(ptype.ptr)
-		}
+		ptype = convert_array(ptype)
 		paramtypes = append(paramtypes, ptype)
 		if !typeonly {
 			paramvars = append(paramvars, ast_lvar(ptype, name))
@@ -1566,13 +3041,20 @@ func read_func_param_list(rettype *Ctype, paramvars []*Ast) (*Ctype, []*Ast) {
 func read_func_body(functype *Ctype, fname string, params []*Ast) *Ast {
 	localenv = MakeDict(localenv)
 	localvars = make([]*Ast, 0)
+	labels = MakeDict(nil)
+	gotos = nil
 	current_func_type = functype
-	body := read_compound_stmt()
-	r := ast_func(functype, fname, params, localvars, body)
+	// Define the function before its body for recursive calls.
+	r := ast_func(functype, fname, params, nil, nil)
 	globalenv.PutAst(fname, r)
+	r.body = read_compound_stmt()
+	resolve_gotos()
+	r.localvars = localvars
 	current_func_type = nil
 	localenv = nil
 	localvars = nil
+	labels = nil
+	gotos = nil
 	return r
 }
 
@@ -1614,23 +3096,66 @@ func is_funcdef() bool {
 
 func read_funcdef() *Ast {
 	var name string
-	basetype, _ := read_decl_spec()
+	basetype, sclass := read_decl_spec()
 	localenv = MakeDict(globalenv)
+	tagenv = MakeDict(tagenv)
 	var params []*Ast = make([]*Ast, 0)
 	functype, params := read_declarator(&name, basetype, params, DECL_BODY)
 	expect('{')
+	prev := globalenv.GetAst(name)
 	r := read_func_body(functype, name, params)
+	r.isstatic = sclass == S_STATIC || (prev != nil && prev.isstatic)
 	localenv = nil
+	tagenv = tagenv.Parent()
 	return r
 }
 
+// storage_var returns the function making a variable declared with the
+// storage class. In a block, a static variable is given a unique label
+// and an extern one refers to the global variable. At file scope, a
+// variable or a function once declared static stays static.
+func storage_var(make_var MakeVarFn, sclass int) MakeVarFn {
+	if localenv != nil {
+		switch sclass {
+		case S_STATIC:
+			return func(ctype *Ctype, name string) *Ast {
+				r := ast_local_gvar(ctype, name, make_label())
+				r.isstatic = true
+				return r
+			}
+		case S_EXTERN:
+			return func(ctype *Ctype, name string) *Ast {
+				return ast_local_gvar(ctype, name, name)
+			}
+		}
+		return make_var
+	}
+	return func(ctype *Ctype, name string) *Ast {
+		prev := globalenv.GetAst(name)
+		r := make_var(ctype, name)
+		r.isstatic = sclass == S_STATIC || (prev != nil && prev.isstatic)
+		return r
+	}
+}
+
+// add_decl appends a declaration to the block. The declaration of a
+// static variable in a function is emitted after the function instead.
+func add_decl(block []*Ast, decl *Ast) []*Ast {
+	if localenv != nil && decl.declvar.typ == AST_GVAR {
+		static_locals = append(static_locals, decl)
+		return block
+	}
+	return append(block, decl)
+}
+
 func read_decl(block []*Ast, make_var MakeVarFn) []*Ast {
 	basetype, sclass := read_decl_spec()
 	tok := read_token()
 	if tok.is_punct(';') {
-		return nil
+		return block
 	}
 	unget_token(tok)
+	make_var = storage_var(make_var, sclass)
 	for {
 		var name string
 		ctype, _ := read_declarator(&name, basetype, nil, DECL_BODY)
@@ -1640,16 +3165,19 @@ func read_decl(block []*Ast, make_var MakeVarFn) []*Ast {
 				errorf("= after typedef")
 			}
 			gvar := make_var(ctype, name)
-			block = append(block, read_decl_init(gvar))
+			block = add_decl(block, read_decl_init(gvar))
 			tok = read_token()
 		} else if sclass == S_TYPEDEF {
-			typedefs.PutCtype(name, ctype)
+			env().PutCtype(name, ctype)
 		} else if ctype.typ == CTYPE_FUNC {
 			make_var(ctype, name)
 		} else {
+			if sclass != S_EXTERN && is_incomplete(ctype) {
+				errorf("variable has incomplete type: %s", name)
+			}
 			gvar := make_var(ctype, name)
 			if sclass != S_EXTERN {
-				block = append(block, ast_decl(gvar, nil))
+				block = add_decl(block, ast_decl(gvar, nil))
 			}
 		}
 
@@ -1670,6 +3198,8 @@ func read_toplevels() []*Ast {
 		}
 		if is_funcdef() {
 			r = append(r, read_funcdef())
+			r = append(r, static_locals...)
+			static_locals = nil
 		} else {
 			r = read_decl(r, ast_gvar)
 		}
@@ -51,10 +51,23 @@ function testfail {
 testast '(() -> int)f(){1;}' '1;'
 testast '(() -> int)f(){1L;}' '1L;'
 testast '(() -> int)f(){1152921504606846976L;}' '1152921504606846976;'
+testast '(() -> int)f(){1U;}' '1u;'
+testast '(() -> int)f(){1UL;}' '1ul;'
+testast '(() -> int)f(){1ULL;}' '1ULL;'
+testast '(() -> int)f(){4294967295U;}' '0xffffffff;'
+testast '(() -> int)f(){3000000000L;}' '3000000000;'
+testast '(() -> int)f(){1LL;}' '1ll;'
+testast '(() -> int)f(){9223372036854775808ULL;}' '0x8000000000000000LL;'
+testast '(() -> int)f(){5;}' '0b101;'
+testast '(() -> int)f(){100.000000;}' '1e2;'
+testast '(() -> int)f(){0.375000;}' '0x1.8p-2;'
+testast '(() -> int)f(){(decl unsigned int a);}' 'unsigned a;'
 testast '(() -> int)f(){(+ (- (+ 1 2) 3) 4);}' '1+2-3+4;'
 testast '(() -> int)f(){(+ (+ 1 (* 2 3)) 4);}' '1+2*3+4;'
 testast '(() -> int)f(){(+ (* 1 2) (* 3 4));}' '1*2+3*4;'
 testast '(() -> int)f(){(+ (/ 4 2) (/ 6 3));}' '4/2+6/3;'
+testast '(() -> int)f(){(!= 1 2);}' '1!=2;'
+testast '(() -> int)f(){(decl [2]int a);}' 'int a<:2:>;'
 testast '(() -> int)f(){(/ (/ 24 2) 4);}' '24/2/4;'
 testast '(() -> int)f(){(decl int a 3);}' 'int a=3;'
 testast "(() -> int)f(){(decl char c 'a');}" "char c='a';"
@@ -63,6 +76,8 @@ testast "(() -> int)f(){(decl [5]char s {'a','s','d','f','\0'});}" 'char s[5]="a
 testast "(() -> int)f(){(decl [5]char s {'a','s','d','f','\0'});}" 'char s[]="asdf";'
 testast '(() -> int)f(){(decl [3]int a {1,2,3});}' 'int a[3]={1,2,3};'
 testast '(() -> int)f(){(decl [3]int a {1,2,3});}' 'int a[]={1,2,3};'
+testast '(() -> int)f(){(decl [3]int a {1,3});}' 'int a[3]={1,[2]=3};'
+testast '(() -> int)f(){(decl [2][2]int a {1,2,3});}' 'int a[][2]={{1,2},3};'
 testast '(() -> int)f(){(decl [3][5]int a);}' 'int a[3][5];'
 testast '(() -> int)f(){(decl [5]*int a);}' 'int *a[5];'
 testast '(() -> int)f(){(decl int a 1);(decl int b 2);(= a (= b 3));}' 'int a=1;int b=2;a=b=3;'
@@ -72,8 +87,20 @@ testast '(() -> int)f(){(decl int a 3);(decl *int b (addr a));(deref b);}' 'int
 testast '(() -> int)f(){(if 1 {2;});}' 'if(1){2;}'
 testast '(() -> int)f(){(if 1 {2;} {3;});}' 'if(1){2;}else{3;}'
 testast '(() -> int)f(){(for (decl int a 1) 3 7 {5;});}' 'for(int a=1;3;7){5;}'
+testast '(() -> int)f(){(while 1 {5;});}' 'while(1){5;}'
+testast '(() -> int)f(){(do 1 {5;});}' 'do{5;}while(1);'
+testast '(() -> int)f(){(while 1 {(break);});}' 'while(1){break;}'
+testast '(() -> int)f(){(for (nil) (nil) (nil) {(continue);});}' 'for(;;){continue;}'
+testast '(() -> int)f(){(switch 1 {(case 1 (break));(default 3);});}' 'switch(1){case 1: break; default: 3;}'
+testast '(() -> int)f(){(label a (goto a));}' 'a: goto a;'
+testast '(() -> int)f(){(goto b);(label b 1);}' 'goto b; b: 1;'
 testast '(() -> int)f(){"abcd";}' '"abcd";'
 testast "(() -> int)f(){'c';}" "'c';"
+testast '(() -> int)f(){"a\tb\000c";}' '"a\tb" "\0c";'
+testast "(() -> int)f(){24930;}" "'ab';"
+testast '(() -> int)f(){L"ab";}' 'L"a" "b";'
+testast '(() -> int)f(){L"a\xd800";}' 'L"a" "\xd800";'
+testast '(() -> int)f(){12354;}' "L'\\u3042';"
 testast '(() -> int)f(){(int)a();}' 'a();'
 testast '(() -> int)f(){(int)a(1,2,3,4,5,6);}' 'a(1,2,3,4,5,6);'
 testast '(() -> int)f(){(return 1);}' 'return 1;'
@@ -81,34 +108,175 @@ testast '(() -> int)f(){(< 1 2);}' '1<2;'
 testast '(() -> int)f(){(> 1 2);}' '1>2;'
 testast '(() -> int)f(){(== 1 2);}' '1==2;'
 testast '(() -> int)f(){(deref (+ 1 2));}' '1[2];'
-testast '(() -> int)f(){(decl int a 1);(++ a);}' 'int a=1;a++;'
-testast '(() -> int)f(){(decl int a 1);(-- a);}' 'int a=1;a--;'
+testast '(() -> int)f(){(decl int a 1);(post++ a);}' 'int a=1;a++;'
+testast '(() -> int)f(){(decl int a 1);(post-- a);}' 'int a=1;a--;'
+testast '(() -> int)f(){(decl int a 1);(pre++ a);}' 'int a=1;++a;'
+testast '(() -> int)f(){(decl int a 1);(pre-- a);}' 'int a=1;--a;'
+testast '(() -> int)f(){(decl *int p);(deref (post++ p));}' 'int *p;*p++;'
+testast '(() -> int)f(){(decl *int p);(post++ (deref p));}' 'int *p;(*p)++;'
 testast '(() -> int)f(){(! 1);}' '!1;'
 testast '(() -> int)f(){(? 1 2 3);}' '1?2:3;'
 testast '(() -> int)f(){(and 1 2);}' '1&&2;'
 testast '(() -> int)f(){(or 1 2);}' '1||2;'
 testast '(() -> int)f(){(& 1 2);}' '1&2;'
 testast '(() -> int)f(){(| 1 2);}' '1|2;'
+testast '(() -> int)f(){(% 5 2);}' '5%2;'
+testast '(() -> int)f(){(^ 1 2);}' '1^2;'
+testast '(() -> int)f(){(<< 1 2);}' '1<<2;'
+testast '(() -> int)f(){(>> 1 2);}' '1>>2;'
+testast '(() -> int)f(){(~ 1);}' '~1;'
+testast '(() -> int)f(){(decl int a 1);(+= a 2);}' 'int a=1;a+=2;'
+testast '(() -> int)f(){(decl int a 1);(<<= a (>>= a 2));}' 'int a=1;a<<=a>>=2;'
+testast '(() -> int)f(){(decl *int p);(-= (deref p) 2);}' 'int *p;*p-=2;'
+testast '(() -> int)f(){(+ (- 0 1) 2);}' '-1+2;'
+testast '(() -> int)f(){((long) 1);}' '(long)1;'
+testast '(() -> int)f(){(decl int a);((*char) (addr a));}' 'int a;(char*)&a;'
+testast '(() -> int)f(){(+ ((double) 1) 2);}' '(double)1+2;'
+testast '(() -> int)f(){((void) (* 1.500000L 2));}' '1.5L*2;'
 testast '(() -> int)f(){1.200000;}' '1.2;'
 testast '(() -> int)f(){(+ 1.200000 1);}' '1.2+1;'
 
 testastf '((int) -> int)f(int c){c;}' 'int f(int c){c;}'
+testastf '(() -> int)f(){1;}' 'int f(void){1;}'
+testastf '((int) -> int)f(int a){(decl [1](struct (unsigned int) (unsigned int) (*int) (*int)) ap);(va_start ap);(va_arg ap *char);}' 'int f(int a, ...){__builtin_va_list ap; __builtin_va_start(ap, a); __builtin_va_arg(ap, char *);}'
+testastf '((int,int,int,int,int,int,int) -> int)f(int a,int b,int c,int d,int e,int f,int g){g;}' 'int f(int a,int b,int c,int d,int e,int f,int g){g;}'
 testastf '((int) -> int)f(int c){c;}((int) -> int)g(int d){d;}' 'int f(int c){c;} int g(int d){d;}'
 testastf '(decl int a 3)' 'int a=3;'
+testastf '(decl long a 3L)' 'long a=3;'
+testastf '(decl double a 1.000000)' 'double a=1;'
+testastf '(decl long double a 3.000000L)' 'long double a=1.5L*2;'
+testastf '(decl *char p "a")' 'char *p="a";'
+testastf '(decl [2]int a {1,2})(decl *int p (addr (deref (+ a 1))))' 'int a[2]={1,2}; int *p=&a[1];'
+testastf '((int) -> int)f(int a){(return (+ a 1));}(() -> int)g(){(decl *(int) -> int p f);(int)(*p)(1);}' 'int f(int a){return a+1;} int g(){int (*p)(int)=f; p(1);}'
+testastf '((int) -> int)f(int a){(return a);}(() -> int)g(){(decl *(int) -> int p (addr f));(int)(*(deref p))(1);}' 'int f(int a){return a;} int g(){int (*p)(int)=&f; (*p)(1);}'
+testastf '((*(int) -> int) -> int)f(*(int) -> int p){(int)(*p)(1);}' 'int f(int p(int)){p(1);}'
 
 testastf '(decl (struct) a)' 'struct {} a;'
 testastf '(decl (struct (int) (char)) a)' 'struct {int x; char y;} a;'
 testastf '(decl (struct ([3]int)) a)' 'struct {int x[3];} a;'
 testast '(() -> int)f(){(decl (struct (int)) a);(decl *(struct (int)) p);(deref p).x;}' 'struct tag {int x;} a; struct tag *p; p->x;'
 testast '(() -> int)f(){(decl (struct (int)) a);a.x;}' 'struct {int x;} a; a.x;'
+testast '(() -> int)f(){(decl (struct (int)) a);(decl (struct (int)) b);(= a b);}' 'struct tag {int x;} a; struct tag b; a = b;'
 
 testfail '0abc;'
+testfail '08;'
+testfail '0x;'
+testfail '0b2;'
+testfail '1uu;'
+testfail '1lL;'
+testfail '1f;'
+testfail '18446744073709551616;'
+testfail '1e;'
+testfail '1e+;'
+testfail '0x1.8;'
+testfail '1.5u;'
+testfail '1.5ff;'
+testfail '0x1_0p0;'
 testfail '1+;'
 testfail '1=2;'
+testfail '1+=2;'
+testfail '1++;'
+testfail '++1;'
+testfail 'int a; int *p; a+=p;'
+testfail '1.0%2;'
+testfail '1<<2.0;'
+testfail '~1.0;'
+testfail 'struct {int x;} s; (int)s;'
+testfail '(struct {int x;})1;'
+testfail 'do{5;}while(1)'
+testfail 'break;'
+testfail 'continue;'
+testfail 'case 1: 2;'
+testfail 'default: 2;'
+testfail 'switch(1){case 1: 2; case 1: 3;}'
+testfail 'switch(1){default: 2; default: 3;}'
+testfail 'switch(1){continue;}'
+testfail 'int a=1; switch(1){case a: 2;}'
+testfail 'goto a;'
+testfail 'a: 1; a: 2;'
+testfail 'int a; a(1);'
+testfail 'int f(int a); f();'
+testfail 'int (*p)(int); p(1, 2, 3, 4, 5, 6, 7);'
+testfail 'int (*p)(int); p(1, 2);'
+testfail 'int g(void); g(1);'
+testfail '__builtin_va_list ap; __builtin_va_start(ap, 1);'
+testfail 'int a; __builtin_va_arg(a, int);'
+testfail 'struct {int x;} a; struct {int x;} b; a = b;'
+testfail 'struct {int x;} a; a = 1;'
+testfail 'struct {int x;} a; int b = a;'
+testfail 'struct {double x : 3;} a;'
+testfail 'struct {int x : 33;} a;'
+testfail 'struct {int x : 0;} a;'
+testfail 'struct {int x : 3;} a; sizeof(a.x);'
+testfail 'struct {int x;} a; a.y;'
+testfail '_Alignas(3) int a;'
+testfail '_Alignas(32) int a;'
+testfail 'struct {_Alignas(32) char c;} a;'
+testfail 'struct s a;'
+testfail 'struct s *p; sizeof(*p);'
+testfail 'sizeof(struct s);'
+testfail 'struct s *p; p->x;'
+testfail 'struct t {struct s a;};'
+testfail 'struct s {int x;}; struct s {int x;};'
+testfail '{struct s {int x;};} struct s a;'
+testfail '{typedef int u;} u a;'
+testfail 'enum e {A}; struct e *p;'
+testfail 'int a[2] = {1, 2, 3};'
+testfail 'int a[] = 1;'
+testfail 'int a[2] = {[2] = 1};'
+testfail 'int a[2] = {.x = 1};'
+testfail 'struct {int x;} s = {.y = 1};'
+testfail 'struct {int x;} s = {[0] = 1};'
+testfail 'const int a = 1; a = 2;'
+testfail 'const int a = 1; a++;'
+testfail 'const int a = 1; a += 2;'
+testfail 'const char *p; *p = 1;'
+testfail 'int b; int *const p = &b; p = 0;'
+testfail 'const int a[2]; a[0] = 1;'
+testfail 'const struct {int x;} s; s.x = 1;'
+testfail 'struct {const int x;} s, t; s = t;'
+testfail 'struct {const int x; int y;} s; s.x = 1;'
+testfail 'struct {struct {const int x;} n;} s, t; s.n = t.n;'
+testfail 'const struct {struct {int x;} n;} s; s.n.x = 1;'
 
 # & is only applicable to an lvalue
 testfail '&"a";'
+testfail '"\q";'
+testfail '"\x";'
+testfail '"\x100";'
+testfail '"\400";'
+testfail "'';"
+testfail "'abcde';"
+testfail "L'ab';"
+testfail "u'\\U0001F600';"
+testfail '"\ud800";'
+testfail '"\u0041";'
+testfail 'u"a" U"b";'
+testfail 'int a[] = "a";'
+testfail 'char a[] = L"a";'
+testfail 'u"\x10000";'
+testfail 'L"\x100000000";'
+testfail 'L"a" u8"b";'
 testfail '&1;'
+testfail '1 @ 2;'
+testfail '1 ... 2;'
 testfail '&a();'
+testfail 'struct {int x : 3;} a; &a.x;'
+
+# Driver
+echo 'int twice(int x) { return x * 2; }' > tmp1.c
+echo 'int printf(char *, ...); int twice(int); int main() { printf("%d %d", twice(N), M); return 0; }' > tmp2.c
+./8cc -DN=21 -D M -w -o tmp.out tmp1.c tmp2.c && assertequal "$(./tmp.out 2>&1)" '42 1'
+./8cc -c -DN=3 -DM=0 tmp1.c tmp2.c && ./8cc -o tmp.out tmp1.o tmp2.o && assertequal "$(./tmp.out 2>&1)" '6 0'
+assertequal "$(echo 'A B' > tmp.h; echo 'X A' > tmp1.c; ./8cc -E -include tmp.h -DA=1 -UA tmp1.c)" "$(printf 'A B\nX A')"
+rm -f tmp1.o tmp2.o
+echo 'int f( {' > tmp1.c
+./8cc -S tmp1.c 2> /dev/null && echo "Should fail to compile, but succeded: tmp1.c" && exit
+[ -e tmp1.s ] && echo "Test failed: partial output tmp1.s is left" && exit
+./8cc -c tmp1.c 2> /dev/null && echo "Should fail to compile, but succeded: tmp1.c" && exit
+[ -e tmp1.o ] && echo "Test failed: partial output tmp1.o is left" && exit
+./8cc -a tmp1.c tmp2.c 2> /dev/null && echo "Should reject -a with multiple files" && exit
+./8cc -O2 tmp2.c 2> /dev/null && echo "Should reject an unknown option" && exit
+rm -f tmp1.c tmp2.c tmp1.o tmp2.o tmp.h
 
 echo "All tests passed"
@@ -0,0 +1,135 @@
+struct abi_ii { int a; int b; };
+struct abi_iii { int a; int b; int c; };
+struct abi_ld { long a; double b; };
+struct abi_dd { double a; double b; };
+struct abi_ccc { char a; char b; char c; };
+struct abi_big { long a; long b; long c; };
+
+struct abi_ii abi_ii_make(int a, int b);
+struct abi_iii abi_iii_make(int a, int b, int c);
+struct abi_ld abi_ld_make(long a, double b);
+struct abi_dd abi_dd_make(double a, double b);
+struct abi_ccc abi_ccc_make(char a, char b, char c);
+struct abi_big abi_big_make(long a, long b, long c);
+long abi_sum(struct abi_ii ii, struct abi_iii iii, struct abi_ld ld,
+             struct abi_dd dd, struct abi_ccc ccc, struct abi_big big);
+long abi_call(struct abi_ld (*fn)(struct abi_big, struct abi_dd));
+
+int expectl(long a, long b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %ld expected, but got %ld\n", a, b);
+        exit(1);
+    }
+}
+
+int test_assign() {
+    struct abi_iii a;
+    struct abi_iii b;
+    a.a = 1;
+    a.b = 2;
+    a.c = 3;
+    b = a;
+    expect(1, b.a);
+    expect(2, b.b);
+    expect(3, b.c);
+    a.a = 4;
+    expect(1, b.a);
+    struct abi_iii c = a;
+    expect(4, c.a);
+    expect(3, c.c);
+    struct abi_iii *p = &c;
+    *p = b;
+    expect(1, c.a);
+    struct { struct abi_ccc x; int y; } d;
+    d.x.a = 5;
+    d.x.c = 6;
+    struct abi_ccc e = d.x;
+    expect(5, e.a);
+    expect(6, e.c);
+}
+
+int test_return_from_gcc() {
+    struct abi_ii ii = abi_ii_make(1, 2);
+    expect(1, ii.a);
+    expect(2, ii.b);
+    struct abi_iii iii = abi_iii_make(3, 4, 5);
+    expect(3, iii.a);
+    expect(5, iii.c);
+    struct abi_ld ld = abi_ld_make(6, 7.5);
+    expectl(6, ld.a);
+    expectl(75, ld.b * 10);
+    struct abi_dd dd = abi_dd_make(8.5, 9.5);
+    expectl(85, dd.a * 10);
+    expectl(95, dd.b * 10);
+    struct abi_ccc ccc = abi_ccc_make(10, 11, 12);
+    expect(10, ccc.a);
+    expect(11, ccc.b);
+    expect(12, ccc.c);
+    struct abi_big big = abi_big_make(13, 14, 15);
+    expectl(13, big.a);
+    expectl(15, big.c);
+    expect(2, abi_ii_make(1, 2).b);
+    expectl(14, abi_big_make(13, 14, 15).b);
+}
+
+int test_pass_to_gcc() {
+    struct abi_ii ii = abi_ii_make(1, 2);
+    struct abi_iii iii = abi_iii_make(3, 4, 5);
+    struct abi_ld ld = abi_ld_make(6, 7.5);
+    struct abi_dd dd = abi_dd_make(8.5, 9.5);
+    struct abi_ccc ccc = abi_ccc_make(10, 11, 12);
+    struct abi_big big = abi_big_make(13, 14, 15);
+    expectl(120, abi_sum(ii, iii, ld, dd, ccc, big));
+    expectl(120, abi_sum(abi_ii_make(1, 2), iii, ld, dd, ccc, abi_big_make(13, 14, 15)));
+}
+
+struct abi_ld callback(struct abi_big big, struct abi_dd dd) {
+    struct abi_ld r;
+    r.a = big.a + big.b + big.c;
+    r.b = dd.a + dd.b;
+    return r;
+}
+
+int test_called_from_gcc() {
+    expectl(700, abi_call(callback));
+}
+
+struct abi_big big_add(struct abi_big x, struct abi_big y) {
+    struct abi_big r;
+    r.a = x.a + y.a;
+    r.b = x.b + y.b;
+    r.c = x.c + y.c;
+    return r;
+}
+
+struct abi_iii iii_swap(struct abi_iii x) {
+    struct abi_iii r;
+    r.a = x.c;
+    r.b = x.b;
+    r.c = x.a;
+    return r;
+}
+
+int test_local() {
+    struct abi_big x = abi_big_make(1, 2, 3);
+    struct abi_big y = big_add(x, x);
+    expectl(2, y.a);
+    expectl(6, y.c);
+    expectl(9, big_add(x, y).c);
+    struct abi_iii z = iii_swap(abi_iii_make(1, 2, 3));
+    expect(3, z.a);
+    expect(1, z.c);
+    struct abi_iii (*fp)(struct abi_iii) = iii_swap;
+    expect(3, fp(z).c);
+}
+
+int main() {
+    test_assign();
+    test_return_from_gcc();
+    test_pass_to_gcc();
+    test_called_from_gcc();
+    test_local();
+    printf("OK\n");
+    return 0;
+}
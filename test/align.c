@@ -0,0 +1,109 @@
+struct layout {
+    char c;
+    struct { char a[3]; short s; } n;
+    union { int i; char b[5]; };
+    long l;
+    int tail;
+};
+
+long layout_size();
+long layout_sum(struct layout *p);
+long layout_byval(struct layout v);
+
+int expectl(long a, long b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %ld expected, but got %ld\n", a, b);
+        exit(1);
+    }
+}
+
+int t1() {
+    expect(16, sizeof(struct { long a; int b; }));
+    expect(8, _Alignof(struct { long a; int b; }));
+    expect(12, sizeof(struct { char a; int b; char c; }));
+    expect(3, sizeof(struct { char a[3]; }));
+    expect(1, _Alignof(struct { char a[3]; }));
+    expect(6, sizeof(struct { char a; struct { char b; short c; } d; }));
+    expect(8, sizeof(union { int a; char b[5]; }));
+    expect(4, _Alignof(union { int a; char b[5]; }));
+    expect(2, sizeof(struct { char a; char b[1]; }));
+    expect(24, sizeof(struct { char a; struct { long b; } c; char d; }));
+    expect(4, sizeof(struct { struct tag1 { long a; }; int b; }));
+    expect(1, _Alignof(char));
+    expect(2, _Alignof(short));
+    expect(4, _Alignof(int));
+    expect(8, _Alignof(long));
+    expect(8, _Alignof(char *));
+    expect(4, _Alignof(int[3]));
+    int x;
+    expect(4, __alignof__(x));
+}
+
+int t2() {
+    struct layout v;
+    expectl(layout_size(), sizeof(v));
+    v.c = 1;
+    v.n.a[2] = 2;
+    v.n.s = 3;
+    v.i = 4;
+    v.b[4] = 5;
+    v.l = 6;
+    v.tail = 7;
+    expectl(28, layout_sum(&v));
+    expectl(28, layout_byval(v));
+}
+
+int t3() {
+    struct {
+        int a;
+        union { int b; char c; };
+        struct { int d; int e; };
+    } v;
+    v.a = 1;
+    v.b = 2;
+    v.d = 3;
+    v.e = 4;
+    expect(1, v.a);
+    expect(2, v.b);
+    expect(2, v.c);
+    expect(3, v.d);
+    expect(4, v.e);
+    expect(16, sizeof(v));
+    int *p = &v.e;
+    expect(4, *p);
+}
+
+struct aligned {
+    char a;
+    _Alignas(8) char b;
+    _Alignas(long) int c;
+};
+
+_Alignas(16) char gbuf[3];
+int gpad;
+
+int t4() {
+    expect(24, sizeof(struct aligned));
+    expect(8, _Alignof(struct aligned));
+    struct aligned s;
+    expect(8, (long)&s.b - (long)&s);
+    expect(16, (long)&s.c - (long)&s);
+    char c;
+    _Alignas(16) char buf[3];
+    expect(0, (long)buf % 16);
+    expect(0, (long)gbuf % 16);
+    expect(16, _Alignof(gbuf));
+}
+
+int main() {
+    printf("Testing align ... ");
+
+    t1();
+    t2();
+    t3();
+    t4();
+
+    printf("OK\n");
+    return 0;
+}
@@ -20,6 +20,189 @@ int test_inc_dec() {
     expect(16, a);
     expect(16, a--);
     expect(15, a);
+    expect(16, ++a);
+    expect(16, a);
+    expect(15, --a);
+    expect(15, a);
+    expect(17, ++a + 1);
+}
+
+int test_inc_dec_lvalue() {
+    int arr[3] = { 10, 20, 30 };
+    int *p = arr;
+    expect(10, (*p)++);
+    expect(11, arr[0]);
+    expect(12, ++*p);
+    expect(12, arr[0]);
+    expect(12, *p++);
+    expect(20, *p);
+    expect(19, --*p);
+    int i = 1;
+    expect(19, arr[i++]++);
+    expect(20, arr[1]);
+    expect(2, i);
+
+    struct { int x; char c; } s;
+    s.x = 5;
+    s.c = 'a';
+    expect(5, s.x++);
+    expect(6, s.x);
+    expect(7, ++s.x);
+    expect('b', ++s.c);
+    expect('b', s.c--);
+    expect('a', s.c);
+
+    double d = 1.5;
+    d++;
+    ++d;
+    int r = d * 2;
+    expect(7, r);
+    d--;
+    r = --d * 2;
+    expect(3, r);
+
+    float f = 0.5;
+    f++;
+    r = f++ * 2;
+    expect(3, r);
+    r = f * 2;
+    expect(5, r);
+}
+
+
+int test_mod() {
+    expect(1, 7 % 3);
+    expect(-1, -7 % 3);
+    expect(1, 7 % -3);
+    int a = 17;
+    int b = 5;
+    expect(2, a % b);
+    expect(3, a / b);
+    expect(-3, -a / b);
+    expect(-2, -a % b);
+    long c = 10000000000;
+    expect(1, c % 3);
+}
+
+int test_shift() {
+    expect(16, 1 << 4);
+    expect(4, 16 >> 2);
+    expect(-4, -16 >> 2);
+    int a = 3;
+    int b = 2;
+    expect(12, a << b);
+    expect(-1, -1 >> b);
+    expect(6, 1 + 2 << 1);
+    long c = 1;
+    expect(1, (c << 40) >> 40);
+}
+
+int test_xor_not() {
+    expect(6, 5 ^ 3);
+    expect(-6, ~5);
+    expect(-1, ~0);
+    int a = 12;
+    expect(10, a ^ 6);
+    expect(-13, ~a);
+    expect(3, 1 ^ 3 & 6);
+    expect(7, 1 | 2 ^ 4);
+}
+
+int test_unary() {
+    int a = 3;
+    expect(3, +a);
+    expect(-3, -a);
+    expect(1, -2 + 3);
+    expect(-6, -2 * 3);
+    expect(4, sizeof(+'a'));
+}
+
+enum { SHIFTED = 1 << 3, MASKED = 0xff % 7 ^ 1 };
+
+int test_const() {
+    int arr[1 << 2];
+    expect(16, sizeof(arr));
+    expect(8, SHIFTED);
+    expect(2, MASKED);
+#if (3 % 2) && (1 << 2) == 4 && (~0 & 7) == 7
+    expect(1, 1);
+#else
+    expect(1, 0);
+#endif
+}
+
+int test_assign_op() {
+    int a = 5;
+    a += 3;
+    expect(8, a);
+    a -= 1;
+    expect(7, a);
+    a *= 3;
+    expect(21, a);
+    a /= 2;
+    expect(10, a);
+    a %= 6;
+    expect(4, a);
+    a <<= 4;
+    expect(64, a);
+    a >>= 1;
+    expect(32, a);
+    a |= 5;
+    expect(37, a);
+    a &= 13;
+    expect(5, a);
+    a ^= 3;
+    expect(6, a);
+    expect(10, a += 4);
+
+    int b = 1;
+    int c = 2;
+    b += c += 3;
+    expect(6, b);
+    expect(5, c);
+}
+
+int test_assign_op_lvalue() {
+    int arr[4] = { 1, 2, 3, 4 };
+    int i = 0;
+    arr[i++] += 10;
+    expect(11, arr[0]);
+    expect(2, arr[1]);
+    expect(1, i);
+    arr[i++] *= 5;
+    expect(10, arr[1]);
+    expect(2, i);
+
+    int *p = arr;
+    p += 2;
+    expect(3, *p);
+    p -= 1;
+    expect(10, *p);
+    *p -= 1;
+    expect(9, arr[1]);
+
+    struct { int x; int y[3]; } s;
+    s.x = 1;
+    s.y[1] = 2;
+    s.x += 5;
+    s.y[1] *= 7;
+    expect(6, s.x);
+    expect(14, s.y[1]);
+}
+
+int test_assign_op_float() {
+    double d = 1.5;
+    d += 2;
+    d *= 2;
+    int r = d;
+    expect(7, r);
+    d /= 4;
+    d -= 0.5;
+    r = d * 4;
+    expect(5, r);
+    int a = 10;
+    a += 2.7;
+    expect(12, a);
 }
 
 int test_bool() {
@@ -50,6 +233,15 @@ int main() {
 
     test_basic();
     test_inc_dec();
+    test_inc_dec_lvalue();
+    test_mod();
+    test_shift();
+    test_xor_not();
+    test_unary();
+    test_const();
+    test_assign_op();
+    test_assign_op_lvalue();
+    test_assign_op_float();
     test_bool();
     test_ternary();
     test_logand();
@@ -0,0 +1,92 @@
+struct reg {
+    unsigned enable : 1;
+    unsigned mode : 3;
+    int level : 4;
+    unsigned : 0;
+    unsigned count : 12;
+    long big : 40;
+};
+
+int t1() {
+    struct reg r;
+    r.enable = 1;
+    r.mode = 5;
+    r.level = -3;
+    r.count = 4095;
+    r.big = -549755813888;
+    expect(1, r.enable);
+    expect(5, r.mode);
+    expect(-3, r.level);
+    expect(4095, r.count);
+    expect(1, r.big == -549755813888);
+    r.mode = 9;
+    expect(1, r.mode);
+    expect(1, r.enable);
+    expect(-3, r.level);
+    r.level = 7;
+    expect(7, r.level);
+    r.level = 8;
+    expect(-8, r.level);
+}
+
+int t2() {
+    struct reg r;
+    struct reg *p = &r;
+    p->mode = 0;
+    p->mode += 6;
+    expect(6, p->mode);
+    p->mode++;
+    expect(7, p->mode);
+    p->mode++;
+    expect(0, r.mode);
+    expect(6, (p->mode = 14));
+    p->mode = 0;
+    expect(1, r.mode - 1 < 0);
+}
+
+int t3() {
+    union { unsigned char b; struct { unsigned lo : 4; unsigned hi : 4; } n; } u;
+    u.b = 0xa5;
+    expect(5, u.n.lo);
+    expect(10, u.n.hi);
+    u.n.hi = 3;
+    expect(0x35, u.b);
+}
+
+int t4() {
+    expect(16, sizeof(struct reg));
+    expect(4, sizeof(struct { unsigned a : 3; }));
+    expect(4, sizeof(struct { char c; int x : 4; }));
+    expect(4, sizeof(struct { int x : 4; char c; }));
+    expect(16, sizeof(struct { char c; long x : 60; }));
+    expect(2, sizeof(struct { char a : 4; char b : 4; char c : 4; }));
+    expect(8, sizeof(struct { int a : 20; int b : 20; }));
+    expect(4, sizeof(union { int a : 3; char b; }));
+}
+
+int t5() {
+    struct { char c; int x : 4; int y : 27; } s;
+    s.c = 1;
+    s.x = 2;
+    s.y = 3;
+    expect(1, s.c);
+    expect(2, s.x);
+    expect(3, s.y);
+    s.y = -5;
+    expect(-5, s.y);
+    expect(2, s.x);
+    expect(1, s.c);
+}
+
+int main() {
+    printf("Testing bitfield ... ");
+
+    t1();
+    t2();
+    t3();
+    t4();
+    t5();
+
+    printf("OK\n");
+    return 0;
+}
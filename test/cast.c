@@ -0,0 +1,90 @@
+int expectl(long a, long b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %ld expected, but got %ld\n", a, b);
+        exit(1);
+    }
+}
+
+int expectd(double a, double b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %lf expected, but got %lf\n", a, b);
+        exit(1);
+    }
+}
+
+int test_int() {
+    int a = 300;
+    expect(44, (char)a);
+    expect(44, (unsigned char)a);
+    expect(-56, (char)200);
+    expect(200, (unsigned char)200);
+    expect(1, (short)65537);
+    expect(-1, (short)65535);
+    expect(65535, (unsigned short)-1);
+    expectl(-1, (long)-1);
+    expectl(4294967295, (unsigned int)-1);
+    expectl(4294967295, (long)(unsigned int)-1);
+    expectl(-1, (long)(int)4294967295);
+    char c = -1;
+    expectl(-1, (long)c);
+    expect(255, (unsigned char)c);
+    expect(5, (int)(char)(long)5);
+}
+
+int test_float() {
+    expect(3, (int)3.7);
+    expect(-3, (int)-3.7);
+    expectl(1000000000000, (long)1000000000000.0);
+    expectd(3.5, (double)7 / 2);
+    expectd(0.5, (float)0.5);
+    expectd(16777216, (float)16777217);
+    expectd(16777217, (double)16777217);
+    expectd(0.25, (double)(float)0.25);
+    expect(0, (float)0.1 == 0.1);
+    expect(1, (double)0.1 == 0.1);
+    expectd(18446744073709551615.0, (double)(unsigned long)-1);
+    expect(1, (unsigned long)18446744073709549568.0 == (unsigned long)-2048);
+    expect(200, (unsigned char)200.5);
+}
+
+int test_pointer() {
+    int a[] = { 10, 20, 30 };
+    int *p = (int *)0;
+    expectl(8, (long)(p + 2));
+    long addr = (long)a;
+    expect(20, *((int *)addr + 1));
+    char *s = "ab";
+    expect('b', *((char *)(long)s + 1));
+    expect('a', *(char *)a - 10 + 'a');
+    void *v = (void *)a;
+    expect(30, ((int *)v)[2]);
+}
+
+int test_void() {
+    int a = 1;
+    (void)a;
+    (void)(a = 2);
+    expect(2, a);
+}
+
+int test_const_expr() {
+    int arr[(char)260];
+    expect(16, sizeof(arr));
+    expect(8, sizeof((long)1));
+    expect(1, sizeof((char)1));
+}
+
+int main() {
+    printf("Testing cast ... ");
+
+    test_int();
+    test_float();
+    test_pointer();
+    test_void();
+    test_const_expr();
+
+    printf("OK\n");
+    return 0;
+}
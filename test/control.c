@@ -36,11 +36,257 @@ int testfor() {
     expect(10, acc);
 }
 
+int testwhile() {
+    int acc = 0;
+    int i = 0;
+    while (i <= 100) {
+        acc = acc + i;
+        i = i + 1;
+    }
+    expect(5050, acc);
+
+    i = 0;
+    while (i < 3)
+        i = i + 1;
+    expect(3, i);
+}
+
+int testdo() {
+    int acc = 0;
+    int i = 0;
+    do {
+        acc = acc + i;
+        i = i + 1;
+    } while (i <= 100);
+    expect(5050, acc);
+
+    i = 0;
+    do i = i + 1; while (0);
+    expect(1, i);
+}
+
+int testbreak() {
+    int acc = 0;
+    int i;
+    for (i = 0; i < 10; i = i + 1) {
+        if (i == 5)
+            break;
+        acc = acc + i;
+    }
+    expect(10, acc);
+
+    i = 0;
+    while (1) {
+        if (i == 3)
+            break;
+        i = i + 1;
+    }
+    expect(3, i);
+
+    i = 0;
+    do {
+        if (i == 4)
+            break;
+        i = i + 1;
+    } while (1);
+    expect(4, i);
+
+    int j;
+    acc = 0;
+    for (i = 0; i < 3; i = i + 1) {
+        for (j = 0; j < 10; j = j + 1) {
+            if (j == 2)
+                break;
+            acc = acc + 1;
+        }
+    }
+    expect(6, acc);
+}
+
+int testcontinue() {
+    int acc = 0;
+    int i;
+    for (i = 0; i < 10; i = i + 1) {
+        if (i == 5)
+            continue;
+        acc = acc + i;
+    }
+    expect(40, acc);
+
+    acc = 0;
+    i = 0;
+    while (i < 10) {
+        i = i + 1;
+        if (i == 5)
+            continue;
+        acc = acc + i;
+    }
+    expect(50, acc);
+
+    acc = 0;
+    i = 0;
+    do {
+        i = i + 1;
+        if (i == 5)
+            continue;
+        acc = acc + i;
+    } while (i < 10);
+    expect(50, acc);
+
+    int j;
+    acc = 0;
+    for (i = 0; i < 3; i = i + 1) {
+        j = 0;
+        while (j < 4) {
+            j = j + 1;
+            if (j == 2)
+                continue;
+            acc = acc + 1;
+        }
+    }
+    expect(9, acc);
+}
+
+int testswitch1(int x) {
+    switch (x) {
+    case 1:
+        return 10;
+    case 2:
+        return 20;
+    case 5:
+        return 50;
+    }
+    return 0;
+}
+
+int testswitch2(int x) {
+    int r = 0;
+    switch (x) {
+    case 0: r = r + 1;
+    case 1: r = r + 2;
+    case 2: r = r + 4; break;
+    case 3: r = r + 8;
+    case 4: r = r + 16; break;
+    case 5: r = r + 32;
+    default: r = r + 64;
+    }
+    return r;
+}
+
+int testswitch3(long x) {
+    switch (x) {
+    case -100: return 1;
+    case 1000000: return 2;
+    case 10000000000: return 3;
+    default: return 4;
+    }
+}
+
+int testswitch() {
+    expect(10, testswitch1(1));
+    expect(20, testswitch1(2));
+    expect(50, testswitch1(5));
+    expect(0, testswitch1(3));
+    expect(0, testswitch1(-1));
+
+    expect(7, testswitch2(0));
+    expect(6, testswitch2(1));
+    expect(4, testswitch2(2));
+    expect(24, testswitch2(3));
+    expect(16, testswitch2(4));
+    expect(96, testswitch2(5));
+    expect(64, testswitch2(6));
+    expect(64, testswitch2(-1));
+
+    expect(1, testswitch3(-100));
+    expect(2, testswitch3(1000000));
+    expect(3, testswitch3(10000000000));
+    expect(4, testswitch3(3));
+
+    int i;
+    int acc = 0;
+    for (i = 0; i < 6; i = i + 1) {
+        switch (i) {
+        case 1:
+            continue;
+        case 3:
+            break;
+        default:
+            acc = acc + i;
+        }
+        acc = acc + 100;
+    }
+    expect(511, acc);
+
+    acc = 0;
+    switch (2) {
+    case 1:
+        acc = 1;
+        break;
+    case 2:
+        switch (acc) {
+        case 0:
+            acc = 5;
+            break;
+        }
+        acc = acc + 1;
+        break;
+    }
+    expect(6, acc);
+
+    switch (3) {
+    }
+    switch (3)
+    case 3:
+        acc = 9;
+    expect(9, acc);
+}
+
+int testgoto() {
+    int acc = 0;
+    goto a;
+    acc = acc + 1;
+ a:
+    acc = acc + 2;
+    expect(2, acc);
+
+    int i = 0;
+    acc = 0;
+ loop:
+    acc = acc + i;
+    i = i + 1;
+    if (i <= 10)
+        goto loop;
+    expect(55, acc);
+
+    acc = 0;
+    for (i = 0; i < 10; i = i + 1) {
+        int j;
+        for (j = 0; j < 10; j = j + 1) {
+            if (i * j == 12)
+                goto out;
+            acc = acc + 1;
+        }
+    }
+ out:
+    expect(26, acc);
+    expect(2, i);
+    goto end;
+ end:
+    ;
+}
+
 int main() {
     printf("Testing control flow ... ");
 
     testif();
     testfor();
+    testwhile();
+    testdo();
+    testbreak();
+    testcontinue();
+    testswitch();
+    testgoto();
 
     printf("OK\n");
     return 0;
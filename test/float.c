@@ -22,6 +22,81 @@ double td1(float a)  { return a; }
 double td2(double a) { return a; }
 double td3(int a)    { return a; }
 
+float fsum(float a, float b, float c, float d, float e, float f, float g, float h, float i) {
+    return a + b + c + d + e + f + g + h + i;
+}
+double fmix(float a, double b, float c) { return a + b + c; }
+
+struct fpair { float x; float y; };
+struct fpair fp_make(float x, float y) {
+    struct fpair r;
+    r.x = x;
+    r.y = y;
+    return r;
+}
+
+struct abi_ff { float a; float b; };
+float abi_fmadd(float a, float b, float c);
+float abi_ff_sum(struct abi_ff v);
+float abi_fcall(float (*fn)(float, double));
+float fcallback(float a, double b) { return a * b; }
+
+float gf = 1.25f;
+float gfa[] = { 0.1f, 2.5, 3 };
+
+int test_single() {
+    float a = 16777216.0f;
+    expect(1, a + 1.0f == a);
+    expect(0, a + 1.0 == a);
+    expect(4, sizeof(1.5f));
+    expect(8, sizeof(1.5));
+    expect(4, sizeof(a + 1.0f));
+    expect(8, sizeof(a + 1.0));
+    expect(4, sizeof(a + 1));
+    float b = 0.1f;
+    expect(1, b == 0.1f);
+    expect(0, b == 0.1);
+    expect(1, b + 0.2f == 0.3f);
+    float c = 1.0f / 3.0f;
+    expect(1, c * 3.0f == 1.0f);
+    expect(1, (double)c == (double)(1.0f / 3));
+    float *p = &c;
+    *p = 2.5f;
+    expectf(2.5, c);
+    struct { char x; float f; double d; } s;
+    s.f = 0.1f;
+    s.d = s.f;
+    expect(1, s.d == 0.1f);
+    expect(0, s.d == 0.1);
+    expect(4, (int)(s.f * 40));
+    gf = gf * 2;
+    expectf(2.5, gf);
+    expect(1, gfa[0] == 0.1f);
+    expectf(2.5, gfa[1]);
+    expectf(45, fsum(1, 2, 3, 4, 5, 6, 7, 8, 9));
+    expectd(4.5, fmix(1.25f, 2, 1.25f));
+    struct fpair q = fp_make(1.5f, -2.5f);
+    expectf(1.5, q.x);
+    expectf(-2.5, q.y);
+    float u = 4294967040.0f;
+    expect(1, (unsigned long)u == 4294967040);
+    unsigned long big = 18446744073709551615ul;
+    float v = big;
+    expect(1, v == 18446744073709551616.0f);
+    expect(1, (unsigned long)9223372036854775808.0f == 9223372036854775808ul);
+    expectf(7.5, abi_fmadd(2.5f, 2, 2.5f));
+    struct abi_ff ff;
+    ff.a = 0.25f;
+    ff.b = 1.5f;
+    expectf(1.75, abi_ff_sum(ff));
+    expectf(3.375, abi_fcall(fcallback));
+    float w = 0;
+    w += 0.5f;
+    w++;
+    expectf(1.5, w);
+    expect(1, -w < 0);
+}
+
 int main() {
     printf("Testing float ... ");
 
@@ -50,6 +125,15 @@ int main() {
     expectd(11.0, tf3(11.5));
     expectd(10.0, tf3(10));
 
+    expect(1, 1.0 < 2.0);
+    expect(0, 2.0 < 1.0);
+    expect(1, 1.0 <= 1.0);
+    expect(1, 2.5 > 2);
+    expect(0, 2 >= 2.5);
+    expect(1, 0.5 == 0.5);
+
+    test_single();
+
     printf("OK\n");
     return 0;
 }
@@ -64,6 +64,83 @@ void t9() {
     return;
 }
 
+int t10a(int a) {
+    return a + 1;
+}
+
+int t10b(int a) {
+    return a * 2;
+}
+
+int t10c(int (*fn)(int), int a) {
+    return fn(a);
+}
+
+double t10d(double a, int b) {
+    return a * b;
+}
+
+int t10() {
+    int (*fp)(int) = t10a;
+    expect(4, fp(3));
+    expect(4, (*fp)(3));
+    fp = &t10b;
+    expect(6, fp(3));
+    expect(6, t10c(t10b, 3));
+    expect(4, t10c(&t10a, 3));
+    int (*fps[2])(int);
+    fps[0] = t10a;
+    fps[1] = t10b;
+    expect(11, fps[0](10));
+    expect(20, fps[1](10));
+    double (*dp)(double, int) = t10d;
+    int r = dp(1.5, 4);
+    expect(6, r);
+    expect(1, fp == t10b);
+    expect(8, sizeof(fp));
+}
+
+int t11(int n) {
+    if (n == 0)
+        return 0;
+    return n + t11(n - 1);
+}
+
+long many_ints(long a, long b, long c, long d, long e, long f, long g, long h, long i);
+double many_mixed(int a, double b, int c, double d, int e, double f, int g, double h,
+                  int i, double j, int k, double l, int m, double n, int o, double p,
+                  int q, double r);
+long call_many(long (*fn)(long, long, long, long, long, long, long, long, double, double,
+                          double, double, double, double, double, double, double, char));
+
+int t12a(int a, int b, int c, int d, int e, int f, int g, int h) {
+    expect(1, a);
+    expect(6, f);
+    expect(7, g);
+    expect(8, h);
+    return a + b + c + d + e + f + g + h;
+}
+
+long t12b(long a, long b, long c, long d, long e, long f, long g, long h,
+          double i, double j, double k, double l, double m, double n, double o,
+          double p, double q, char r) {
+    return a + b + c + d + e + f + g + h + (i + j + k + l + m + n + o + p + q) * 2 + r;
+}
+
+int t12() {
+    expect(36, t12a(1, 2, 3, 4, 5, 6, 7, 8));
+    int r = many_ints(1, 2, 3, 4, 5, 6, 7, 8, 9);
+    expect(285, r);
+    r = many_mixed(1, 2.5, 3, 4.5, 5, 6.5, 7, 8.5, 9, 10.5, 11, 12.5, 13, 14.5, 15, 16.5, 17, 18.5) * 2;
+    expect(351, r);
+    r = call_many(t12b);
+    expect(126, r);
+    char buf[100];
+    sprintf(buf, "%d %d %d %d %d %d %d %.1f %d %.1f %d %.1f %.1f %.1f %.1f %.1f %.1f %.1f",
+            1, 2, 3, 4, 5, 6, 7, 8.0, 9, 10.0, 11, 12.0, 13.0, 14.0, 15.0, 16.0, 17.0, 18.0);
+    expect_string("1 2 3 4 5 6 7 8.0 9 10.0 11 12.0 13.0 14.0 15.0 16.0 17.0 18.0", buf);
+}
+
 int main() {
     printf("Testing function ... ");
 
@@ -76,6 +153,9 @@ int main() {
     expect(12, t7(3, 4));
     t8(23);
     t9();
+    t10();
+    expect(55, t11(10));
+    t12();
 
     printf("OK\n");
     return 0;
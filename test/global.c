@@ -5,6 +5,27 @@ int x1, x2;
 int x3, x4 = 4;
 int x5 = 5, x6;
 
+struct point { int x; int y; };
+char *s1 = "abc";
+char *s2 = "abc" + 2;
+int *p1 = &val;
+int *p2 = &a2[2];
+int *p3 = a2 + 1;
+int *p4 = 0;
+char *names[] = { "foo", "bar", 0 };
+struct point pt = { 3, 4 };
+int *py = &pt.y;
+struct { char *name; short h; double d; float f; } st = { "st", -7, 2.5, -1.5 };
+double d1 = 1.5;
+double d2[] = { 1, -2.5, 3 / 2 };
+long l1 = 1L << 40;
+short h1 = -3;
+unsigned char c1 = 300;
+int i1 = 2.9;
+int sum(int a, int b) { return a + b; }
+int (*fp)(int, int) = sum;
+union { double d; long l; } u1 = { 1.0 };
+
 int main() {
     printf("Testing global variable ... ");
 
@@ -27,6 +48,29 @@ int main() {
     x6 = 6;
     expect(6, x6);
 
+    expect_string("abc", s1);
+    expect('c', *s2);
+    expect(22, *p1);
+    expect(26, *p2);
+    expect(25, *p3);
+    expect(1, p4 == 0);
+    expect_string("bar", names[1]);
+    expect(1, names[2] == 0);
+    expect(4, *py);
+    expect_string("st", st.name);
+    expect(-7, st.h);
+    expect(25, (int)(st.d * 10));
+    expect(-15, (int)(st.f * 10));
+    expect(15, (int)(d1 * 10));
+    expect(-25, (int)(d2[1] * 10));
+    expect(1, (int)d2[2]);
+    expect(1, l1 == 1099511627776);
+    expect(-3, h1);
+    expect(44, c1);
+    expect(2, i1);
+    expect(5, fp(2, 3));
+    expect(1, u1.l == 4607182418800017408);
+
     printf("OK\n");
     return 0;
 }
@@ -0,0 +1,166 @@
+struct point { int x; int y; };
+struct rect { struct point a; struct point b; };
+struct flags { unsigned a : 3; int b : 5; char c; };
+
+int g1[5] = { 1, 2 };
+int g2[] = { [3] = 4, [1] = 2 };
+int g3[2][3] = { { 1, 2, 3 }, { 4 } };
+int g4[2][3] = { 1, 2, 3, 4, 5 };
+struct point g5[] = { { 1, 2 }, 3, 4, [3].y = 5 };
+struct rect g6 = { .b = { .y = 4 }, .a.x = 1 };
+char g7[] = "abc";
+char g8[2][4] = { "ab", "cde" };
+struct flags g9 = { 5, -3, 7 };
+short g10[3] = { [2] = -1, [0] = 300 };
+long g11 = 1;
+union { int i; char c[4]; } g12 = { 0x01020304 };
+union { char c; int i; } g13 = { .i = 0x01020304 };
+
+int t1() {
+    int a[5] = { 1, 2 };
+    expect(1, a[0]);
+    expect(2, a[1]);
+    expect(0, a[2]);
+    expect(0, a[4]);
+    int b[] = { [3] = 4, [1] = 2 };
+    expect(16, sizeof(b));
+    expect(0, b[0]);
+    expect(2, b[1]);
+    expect(0, b[2]);
+    expect(4, b[3]);
+    int c[] = { 1, [4] = 5, 6 };
+    expect(24, sizeof(c));
+    expect(6, c[5]);
+    int d[3] = { 1, 2, 3, [0] = 7 };
+    expect(7, d[0]);
+    expect(2, d[1]);
+}
+
+int t2() {
+    int a[2][3] = { { 1, 2, 3 }, { 4 } };
+    expect(3, a[0][2]);
+    expect(4, a[1][0]);
+    expect(0, a[1][2]);
+    int b[2][3] = { 1, 2, 3, 4, 5 };
+    expect(4, b[1][0]);
+    expect(5, b[1][1]);
+    expect(0, b[1][2]);
+    int c[][2] = { 1, 2, 3 };
+    expect(16, sizeof(c));
+    expect(3, c[1][0]);
+    expect(0, c[1][1]);
+    int d[2][2] = { [1] = { 5, 6 }, [0][1] = 7 };
+    expect(0, d[0][0]);
+    expect(7, d[0][1]);
+    expect(6, d[1][1]);
+}
+
+int t3() {
+    struct point a[] = { { 1, 2 }, 3, 4, [3].y = 5 };
+    expect(32, sizeof(a));
+    expect(2, a[0].y);
+    expect(3, a[1].x);
+    expect(4, a[1].y);
+    expect(0, a[2].x);
+    expect(0, a[3].x);
+    expect(5, a[3].y);
+    struct rect r = { .b = { .y = 4 }, .a.x = 1 };
+    expect(1, r.a.x);
+    expect(0, r.a.y);
+    expect(0, r.b.x);
+    expect(4, r.b.y);
+    struct rect s = { 1, 2, 3 };
+    expect(3, s.b.x);
+    expect(0, s.b.y);
+    struct point p = { .y = 9 };
+    struct point q[2] = { p, { 7 } };
+    expect(9, q[0].y);
+    expect(7, q[1].x);
+    struct { int a; struct point p; int b; } t = { 1, p, 2 };
+    expect(9, t.p.y);
+    expect(2, t.b);
+}
+
+int t4() {
+    char a[] = "abc";
+    expect(4, sizeof(a));
+    char b[2][4] = { "ab", "cde" };
+    expect('b', b[0][1]);
+    expect(0, b[0][2]);
+    expect('e', b[1][2]);
+    char c[3] = "abc";
+    expect('c', c[2]);
+    struct { char s[4]; int n; } d[] = { "ab", 1, { "cd", 2 } };
+    expect(16, sizeof(d));
+    expect('b', d[0].s[1]);
+    expect(1, d[0].n);
+    expect('d', d[1].s[1]);
+    expect(2, d[1].n);
+    char e[] = { "xy" };
+    expect(3, sizeof(e));
+    int f = { 3 };
+    expect(3, f);
+}
+
+int t5() {
+    struct flags f = { 5, -3, 7 };
+    expect(5, f.a);
+    expect(-3, f.b);
+    expect(7, f.c);
+    struct flags g = { .b = 2 };
+    expect(0, g.a);
+    expect(2, g.b);
+    expect(0, g.c);
+    union { int i; char c[4]; } u = { 0x01020304 };
+    expect(4, u.c[0]);
+    union { char c; int i; } v = { .i = 0x01020304 };
+    expect(0x01020304, v.i);
+    struct { int a; union { int b; char c; }; int d; } w = { 1, .c = 2, 3 };
+    expect(2, w.b);
+    expect(3, w.d);
+    double x[3] = { 1, 2.5 };
+    expect(5, (int)(x[1] * 2));
+    expect(0, (int)x[2]);
+}
+
+int t6() {
+    expect(1, g1[0]);
+    expect(0, g1[4]);
+    expect(16, sizeof(g2));
+    expect(2, g2[1]);
+    expect(4, g2[3]);
+    expect(4, g3[1][0]);
+    expect(0, g3[1][2]);
+    expect(5, g4[1][1]);
+    expect(32, sizeof(g5));
+    expect(4, g5[1].y);
+    expect(5, g5[3].y);
+    expect(1, g6.a.x);
+    expect(4, g6.b.y);
+    expect(4, sizeof(g7));
+    expect('c', g7[2]);
+    expect('e', g8[1][2]);
+    expect(5, g9.a);
+    expect(-3, g9.b);
+    expect(7, g9.c);
+    expect(300, g10[0]);
+    expect(0, g10[1]);
+    expect(-1, g10[2]);
+    expect(1, g11);
+    expect(4, g12.c[0]);
+    expect(0x01020304, g13.i);
+}
+
+int main() {
+    printf("Testing initializer ... ");
+
+    t1();
+    t2();
+    t3();
+    t4();
+    t5();
+    t6();
+
+    printf("OK\n");
+    return 0;
+}
@@ -0,0 +1,204 @@
+#include <stdarg.h>
+
+int expectl(long a, long b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %ld expected, but got %ld\n", a, b);
+        exit(1);
+    }
+}
+
+int expectf(float a, float b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %f expected, but got %f\n", a, b);
+        exit(1);
+    }
+}
+
+int expectd(double a, double b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %lf expected, but got %lf\n", a, b);
+        exit(1);
+    }
+}
+
+int expectld(long double a, long double b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %Lf expected, but got %Lf\n", a, b);
+        exit(1);
+    }
+}
+
+long double abi_ldmadd(long double a, long double b, long double c);
+long double abi_ldmix(int a, long double b, double c, long double d, int e);
+long double abi_ldcall(long double (*fn)(long double, double));
+
+long double ldsub(long double a, double b) { return a - b; }
+
+long double ldsum(int n, ...) {
+    va_list ap;
+    va_start(ap, n);
+    long double r = 0;
+    for (int i = 0; i < n; i++)
+        r += va_arg(ap, long double);
+    va_end(ap);
+    return r;
+}
+
+struct ldpair { char c; long double v; };
+
+long double g1 = 1.5L;
+long double g2 = 3;
+long double g3 = 0.1L;
+struct ldpair g4 = { 'a', -2.5L };
+long double g5[] = { 1, 2.5, 0.3L };
+
+void test_basic() {
+    long double a = 3.5L;
+    long double b = 2;
+    expectld(5.5L, a + b);
+    expectld(1.5L, a - b);
+    expectld(7.0L, a * b);
+    expectld(1.75L, a / b);
+    expect(16, sizeof(long double));
+    expect(16, sizeof(a + 1));
+    expect(16, _Alignof(long double));
+    expect(32, sizeof(struct ldpair));
+    a += 1;
+    expectld(4.5L, a);
+    a++;
+    expectld(5.5L, a);
+    expectld(5.5L, a--);
+    expectld(4.5L, a);
+    a;
+    expectld(4.5L, a);
+}
+
+void test_precision() {
+    long double x = 1.0L / 3;
+    double y = 1.0 / 3;
+    expect(0, x == y);
+    expect(1, (double)x == y);
+    long double big = 1;
+    for (int i = 0; i < 1100; i++)
+        big *= 2;
+    expect(1, big > 1.5 * (1L << 62) * (1L << 62));
+    expect(1, big / big == 1);
+    expect(1, (long double)(1L << 62) + 1 == 4611686018427387905.0L);
+    expect(0, 0.1L == 0.1);
+    expect(0, g3 == 0.1);
+}
+
+void test_conv() {
+    long double a = 7.9L;
+    expect(7, (int)a);
+    expect(-7, (int)-a);
+    expect(7, (char)a);
+    expectl(123456789012345L, (long long)123456789012345.0L);
+    unsigned long u = 18446744073709551615UL;
+    long double b = u;
+    expectl(18446744073709551615UL == (unsigned long)b, 1);
+    expectl(9223372036854775808UL, (unsigned long)9223372036854775808.0L);
+    expectl(12, (unsigned long)12.5L);
+    float f = a;
+    expectf(7.9f, f);
+    double d = a;
+    expectd(7.9, d);
+    long double c = 2.5f;
+    expectld(2.5L, c);
+    c = 0.1;
+    expect(1, c == 0.1);
+    int i = -3;
+    expectld(-3.0L, i);
+}
+
+void test_comp() {
+    long double a = 1.5L, b = 2.5L;
+    expect(1, a < b);
+    expect(0, a > b);
+    expect(1, a <= b);
+    expect(1, a <= 1.5);
+    expect(0, a >= b);
+    expect(1, b >= 2.5f);
+    expect(0, a == b);
+    expect(1, !(a == b));
+    expect(1, a == 1.5);
+    expect(1, 1 < b);
+    expectld(2.5L, a < b ? b : a);
+}
+
+void test_cond() {
+    long double a = 0.0L, b = 0.5L;
+    int n = 0;
+    for (int i = 0; i < 10; i++) {
+        if (a) n++;
+        if (!a) n += 2;
+        n += a ? 100 : 0;
+        n += b && a;
+        n += (a || b) * 10;
+    }
+    expect(120, n);
+    expectld(1.0L, a + 1);
+    n = 0;
+    while (b) { b -= 0.25; n++; }
+    expect(2, n);
+    do n++; while (a);
+    expect(3, n);
+    for (b = 2; b; b--) n++;
+    expect(5, n);
+    double d = 0.5, z = 0;
+    float f = 0.25f;
+    expect(1, d ? 1 : 0);
+    expect(0, !d);
+    expect(1, !z);
+    expect(1, f && d);
+    expect(0, z || (float)z);
+    double nan = z / z;
+    long double lnan = nan;
+    expect(1, nan ? 1 : 0);
+    expect(0, !lnan);
+    expect(1, lnan && nan);
+    expectld(2.5L, a + 2.5L);
+}
+
+void test_global() {
+    expectld(1.5L, g1);
+    expectld(3.0L, g2);
+    expect(1, g3 == 0.1L);
+    expect('a', g4.c);
+    expectld(-2.5L, g4.v);
+    expectld(2.5L, g5[1]);
+    expect(0, g5[2] == 0.3);
+    g1 = g1 * 2;
+    expectld(3.0L, g1);
+}
+
+void test_abi() {
+    expectld(7.0L, abi_ldmadd(2.0L, 3, 1));
+    expectld(15.5L, abi_ldmix(1, 2.5L, 3, 4, 5));
+    expectld(-0.75L, abi_ldcall(ldsub));
+    expectld(6.0L, ldsum(3, 1.0L, 2.0L, 3.0L));
+    long double *p = &g5[0];
+    *p = 4;
+    expectld(4.0L, g5[0]);
+    struct ldpair s = { 'x', 9.5L };
+    expectld(9.5L, s.v);
+    s.v -= 0.5;
+    expectld(9.0L, s.v);
+}
+
+int main() {
+    printf("Testing long double ... ");
+    test_basic();
+    test_precision();
+    test_conv();
+    test_comp();
+    test_cond();
+    test_global();
+    test_abi();
+    printf("OK\n");
+    return 0;
+}
@@ -1,5 +1,98 @@
-int main() {
+int expectl(long a, long b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %ld expected, but got %ld\n", a, b);
+        exit(1);
+    }
+}
+
+int expectd(double a, double b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %lf expected, but got %lf\n", a, b);
+        exit(1);
+    }
+}
+
+#define CAT(x, y) x ## y
+
+void test_int() {
     expect(1, 0x1);
     expect(17, 0x11);
     expect(511, 0777);
+    expect(0, 0);
+    expect(171, 0xab);
+    expect(3054, 0xBeE);
+    expect(255, 0XfF);
+    expect(5, 0b101);
+    expect(10, 0B1010);
+    expectl(1152921504606846976, 0x1000000000000000);
+    expectl(-1, 0xffffffffffffffff);
+    expectl(-1, 18446744073709551615u);
+    expectl(1, 1LL);
+    expectl(255, 0xffLL);
+    expectl(3, 3ull);
+    expectl(4, 4LLU);
+    expectl(5, 5uLL);
+    expectl(6, 6Lu);
+    expect(2, CAT(0x, 2));
+}
+
+void test_int_type() {
+    expect(4, sizeof(2147483647));
+    expect(8, sizeof(2147483648));
+    expect(4, sizeof(0x7fffffff));
+    expect(4, sizeof(0xffffffff));
+    expect(8, sizeof(0x100000000));
+    expect(8, sizeof(1l));
+    expect(8, sizeof(1LL));
+    expect(4, sizeof(1u));
+    expect(8, sizeof(4294967296u));
+    expect(1, 0xffffffff > 0);
+    expect(1, -1 < 0x7fffffff);
+    expect(0, -1 < 0xffffffff);
+    expect(1, -1 < 4294967295);
+    expect(0, -1 < 1u);
+    expect(1, -1 < 1l);
+    expect(0, -1 < 1ul);
+    expect(0, -1 < 1ull);
+    expect(1, -1 < 1LL);
+    expect(1, 0x7fffffffffffffffL > 0);
+    expect(0, -1 < 0x8000000000000000L);
+}
+
+void test_float() {
+    expectd(1000000000.0, 1e9);
+    expectd(0.015, 1.5e-2);
+    expectd(150.0, 1.5E+2);
+    expectd(0.5, .5);
+    expectd(2.0, 2.);
+    expectd(0.25, .25e0);
+    expectd(100.0, 1e2);
+    expectd(3.0, 0x1.8p1);
+    expectd(0.5, 0x.8p0);
+    expectd(1024.0, 0x1p10);
+    expectd(0.0625, 0X1P-4);
+    expectd(255.0, 0xffp0);
+    expectd(17.0, 017.0);
+    expectd(170.0, 017e1);
+    expect(4, sizeof(1e1f));
+    expect(4, sizeof(0x1p1F));
+    expect(8, sizeof(1e1));
+    expect(16, sizeof(1e1L));
+    expect(16, sizeof(0x1p1l));
+    expectd(0.1f, 1e-1f);
+    expect(0, 0.1f == 0.1);
+    expect(1, 1e-1L == 0.1L);
+    expect(1, 1e400L > 1e300);
+    expect(1, 0x1p-16400L > 0);
+}
+
+int main() {
+    printf("Testing number ... ");
+    test_int();
+    test_int_type();
+    test_float();
+    printf("OK\n");
+    return 0;
 }
@@ -26,6 +26,31 @@ int t5() {
     expect(65, *s);
 }
 
+int t6() {
+    int a[] = { 1, 2, 3, 4 };
+    int *p = a;
+    expect(1, *p++);
+    expect(2, *p);
+    expect(3, *++p);
+    expect(3, *p--);
+    expect(1, *--p);
+    p++;
+    p++;
+    expect(3, *p);
+}
+
+int t7() {
+    long a[] = { 5, 6, 7 };
+    long *p = a;
+    p++;
+    expect(6, *p);
+    char *s = "xyz";
+    s++;
+    expect('y', *s);
+    ++s;
+    expect('z', *s);
+}
+
 int main() {
     printf("Testing pointer ... ");
 
@@ -34,6 +59,8 @@ int main() {
     t3();
     t4();
     t5();
+    t6();
+    t7();
 
     printf("OK\n");
     return 0;
@@ -0,0 +1,102 @@
+#include <stdarg.h>
+
+%:define STR(x) #x
+#define XSTR(x) STR(x)
+#define PASTE(x, y) x ## y
+#define DPASTE(x, y) x %:%: y
+#define FIRST(x, ...) x
+#define REST(x, ...) __VA_ARGS__
+
+struct pt { int x; int y; };
+
+int sum(int n, ...) {
+    va_list ap;
+    va_start(ap, n);
+    int r = 0;
+    for (int i = 0; i < n; i++)
+        r += va_arg(ap, int);
+    va_end(ap);
+    return r;
+}
+
+void test_ne() {
+    expect(1, 1 != 2);
+    expect(0, 1 != 1);
+    expect(1, 1 == 1);
+    expect(0, !1);
+    expect(1, !0 != 0);
+    int a = 3;
+    expect(1, a != 4 && a == 3);
+#if 1 != 2
+    expect(1, 1);
+#else
+    expect(1, 0);
+#endif
+#if 1 != 1
+    expect(1, 0);
+#endif
+}
+
+void test_ops() {
+    int a = 7;
+    expect(1, a % 3);
+    expect(5, a ^ 2);
+    expect(-8, ~a);
+    a %= 4;
+    expect(3, a);
+    a ^= 1;
+    expect(2, a);
+    a <<= 3;
+    expect(16, a);
+    a >>= 2;
+    expect(4, a);
+    a |= 3;
+    expect(7, a);
+    a &= 5;
+    expect(5, a);
+    expect(1, 2 <= 2 && 3 >= 3);
+    expect(1, 0 || 1);
+    struct pt p = { 1, 2 };
+    struct pt *q = &p;
+    expect(2, q->y);
+    expect(6, sum(3, 1, 2, 3));
+}
+
+void test_digraph() <%
+    int a<:3:> = <% 1, 2, 3 %>;
+    expect(2, a<:1:>);
+    expect(3, a[2]);
+    expect_string("<:", STR(<:));
+%:if 1
+    expect(1, 1);
+%:else
+    expect(1, 0);
+%:endif
+%>
+
+void test_macro() {
+    expect_string("a->b != c", STR(a->b != c));
+    expect_string("x <<= 1 ... y", STR(x <<= 1 ... y));
+    expect_string("%: ## #", STR(%: ## #));
+    expect(12, PASTE(1, 2));
+    expect(3, DPASTE(1, 2) - 9);
+    struct pt p = { 1, 2 };
+    struct pt *q = &p;
+    expect(2, q PASTE(-, >) y);
+    int a = 1;
+    a PASTE(<<, =) 2;
+    expect(4, a);
+    expect_string("->", XSTR(PASTE(-, >)));
+    expect(1, FIRST(1, 2, 3));
+    expect(5, sum(REST(0, 2, 2, 3)));
+}
+
+int main() {
+    printf("Testing punct ... ");
+    test_ne();
+    test_ops();
+    test_digraph();
+    test_macro();
+    printf("OK\n");
+    return 0;
+}
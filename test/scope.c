@@ -1,6 +1,7 @@
-int main() {
-    printf("Testing scope ... ");
+typedef int T;
+struct tag { int a; };
 
+int t1() {
     int a = 31;
     { int a = 64; }
     expect(31, a);
@@ -8,6 +9,83 @@ int main() {
         int a = 64;
         expect(64, a);
     }
+}
+
+int t2() {
+    struct tag { char a[10]; };
+    expect(10, sizeof(struct tag));
+    {
+        struct tag { long a; long b; };
+        expect(16, sizeof(struct tag));
+    }
+    expect(10, sizeof(struct tag));
+}
+
+int t3() {
+    expect(4, sizeof(struct tag));
+    typedef char T;
+    expect(1, sizeof(T));
+    {
+        int T = 5;
+        expect(5, T);
+    }
+    T c = 3;
+    expect(3, c);
+}
+
+struct list;
+struct list *make_list();
+
+struct list {
+    int val;
+    struct list *next;
+};
+
+struct list *make_list() {
+    return 0;
+}
+
+int t4() {
+    struct list a;
+    struct list b;
+    a.val = 1;
+    a.next = &b;
+    b.val = 2;
+    b.next = 0;
+    expect(3, a.val + a.next->val);
+    expect(16, sizeof(struct list));
+
+    struct node *p;
+    struct node { int x; int y; } n;
+    p = &n;
+    p->y = 6;
+    expect(6, n.y);
+    {
+        struct node;
+        struct node { char c; } m;
+        expect(1, sizeof(m));
+    }
+}
+
+int t5() {
+    enum color { RED, GREEN };
+    enum color c = GREEN;
+    expect(1, c);
+    {
+        enum color { BLUE = 5 };
+        expect(5, BLUE);
+    }
+}
+
+int main() {
+    printf("Testing scope ... ");
+
+    t1();
+    t2();
+    t3();
+    t4();
+    t5();
+    expect(4, sizeof(T));
 
     printf("OK\n");
     return 0;
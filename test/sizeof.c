@@ -3,6 +3,8 @@ int main() {
     expect(2, sizeof(short));
     expect(4, sizeof(int));
     expect(8, sizeof(long));
+    expect(4, sizeof(float));
+    expect(8, sizeof(double));
 
     expect(8, sizeof(char *));
     expect(8, sizeof(short *));
@@ -17,6 +19,7 @@ int main() {
     expect(4, sizeof 1);
     expect(8, sizeof 1L);
     expect(8, sizeof 1.0);
+    expect(4, sizeof 1.0f);
 
     expect(1, sizeof(char[1]));
     expect(7, sizeof(char[7]));
@@ -0,0 +1,95 @@
+// util.o also defines externvar1 and abi_sum, so this links only if
+// the static ones here are not global.
+static int externvar1 = 5;
+static long abi_sum(void) { return 7; }
+
+static int sv;
+int gv;
+const int cv = 11;
+const char *names[] = { "a", "b" };
+const struct { int x; int y; } cpt = { 1, 2 };
+struct { const int x; int y; } gcm = { 1, 2 };
+
+int counter() {
+    static int n;
+    static int m = 10;
+    n++;
+    m += 2;
+    return n * 100 + m;
+}
+
+int counter2() {
+    static int n = 3;
+    return n++;
+}
+
+int *addr() {
+    static int x = 4;
+    return &x;
+}
+
+int shadow() {
+    int gv = 50;
+    {
+        extern int gv;
+        gv++;
+    }
+    return gv;
+}
+
+int outer() {
+    extern int externvar2;
+    return externvar2;
+}
+
+int t1() {
+    expect(5, externvar1);
+    expect(7, abi_sum());
+    expect(112, counter());
+    expect(214, counter());
+    expect(316, counter());
+    expect(3, counter2());
+    expect(4, counter2());
+    expect(4, *addr());
+    *addr() = 9;
+    expect(9, *addr());
+    expect(50, shadow());
+    expect(1, gv);
+    sv = 6;
+    expect(6, sv);
+    expect(99, outer());
+}
+
+int t2() {
+    expect(11, cv);
+    expect_string("b", names[1]);
+    names[0] = "c";
+    expect_string("c", names[0]);
+    expect(2, cpt.y);
+    const int a = 3;
+    int *p = &a;
+    expect(3, *p);
+    int b = 4;
+    int *const q = &b;
+    *q = 5;
+    expect(5, b);
+    struct { const int x; int y; } s = { 6, 7 };
+    s.y = 8;
+    expect(8, s.y);
+    struct { struct { const int x; int y; } n; } t = { { 9, 10 } };
+    t.n.y++;
+    expect(11, t.n.y);
+    gcm.y = 3;
+    expect(3, gcm.y);
+    expect(1, gcm.x);
+}
+
+int main() {
+    printf("Testing static ... ");
+
+    t1();
+    t2();
+
+    printf("OK\n");
+    return 0;
+}
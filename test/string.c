@@ -0,0 +1,84 @@
+int strlen(char *s);
+int memcmp(void *a, void *b, long n);
+
+#define STR(x) #x
+
+char gs[] = "x" "y\tz";
+char *gp = "a\0b";
+
+void test_escape() {
+    expect(7, "\a"[0]);
+    expect(8, "\b"[0]);
+    expect(12, "\f"[0]);
+    expect(10, "\n"[0]);
+    expect(13, "\r"[0]);
+    expect(9, "\t"[0]);
+    expect(11, "\v"[0]);
+    expect(39, "\'"[0]);
+    expect(34, "\""[0]);
+    expect(63, "\?"[0]);
+    expect(92, "\\"[0]);
+    expect(0, "\0"[0]);
+    expect(10, "\12"[0]);
+    expect(83, "\123"[0]);
+    expect(52, "\1234"[1]);
+    expect(-1, "\377"[0]);
+    expect(26, "\x1a"[0]);
+    expect(-85, "\xAb"[0]);
+    expect(103, "\x0g"[1]);
+    expect(4, sizeof("a\0b"));
+    expect('b', gp[2]);
+}
+
+void test_char() {
+    expect(7, '\a');
+    expect(10, '\n');
+    expect(0, '\0');
+    expect(39, '\'');
+    expect(34, '"');
+    expect(34, '\"');
+    expect(92, '\\');
+    expect(63, '\?');
+    expect(8, '\10');
+    expect(-1, '\377');
+    expect(-128, '\x80');
+    expect(127, '\x7f');
+    expect(0x6162, 'ab');
+    expect(0x61626364, 'abcd');
+    expect(0x0a00, '\n\0');
+    expect(4, sizeof('ab'));
+}
+
+void test_concat() {
+    expect_string("abc", "a" "b" "c");
+    expect_string("abc", "a"
+                  "bc");
+    expect(6, sizeof("ab" "cde"));
+    expect_string("xy\tz", gs);
+    expect(5, sizeof(gs));
+    char s[] = "1" "2";
+    expect(3, sizeof(s));
+    expect_string("\"a\\n\"", STR("a\n"));
+    expect_string("'\\''", STR('\''));
+}
+
+void test_long() {
+    char *s = "0123456789012345678901234567890123456789012345678901234567890123456789"
+        "0123456789012345678901234567890123456789012345678901234567890123456789"
+        "0123456789012345678901234567890123456789012345678901234567890123456789"
+        "0123456789012345678901234567890123456789012345678901234567890123456789";
+    expect(280, strlen(s));
+    expect('9', s[279]);
+    char *t = "01234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789";
+    expect(290, strlen(t));
+}
+
+int main() {
+    printf("Testing string ... ");
+    test_escape();
+    test_char();
+    test_concat();
+    test_long();
+    printf("OK\n");
+    return 0;
+}
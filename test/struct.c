@@ -118,6 +118,21 @@ int t14() {
     expect(2, v.a[1]);
 }
 
+int t15() {
+    struct tag15 { int a, b; };
+    struct { int x; struct tag15 p; struct tag15 q; } v;
+    v.x = 1;
+    v.p.a = 2;
+    v.p.b = 3;
+    v.q.a = 4;
+    v.q.b = 5;
+    expect(1, v.x);
+    expect(2, v.p.a);
+    expect(3, v.p.b);
+    expect(4, v.q.a);
+    expect(5, v.q.b);
+}
+
 int main() {
     printf("Testing struct ... ");
 
@@ -135,6 +150,7 @@ int main() {
     t12();
     t13();
     t14();
+    t15();
 
     printf("OK\n");
     return 0;
@@ -0,0 +1,111 @@
+int expectl(long a, long b) {
+    if (!(a == b)) {
+        printf("Failed\n");
+        printf("  %ld expected, but got %ld\n", a, b);
+        exit(1);
+    }
+}
+
+int test_load() {
+    char c = -1;
+    unsigned char uc = 255;
+    short s = -2;
+    unsigned short us = 65534;
+    int i = -3;
+    unsigned int ui = 4294967293;
+    expectl(-1, c);
+    expectl(255, uc);
+    expectl(-2, s);
+    expectl(65534, us);
+    expectl(-3, i);
+    expectl(4294967293, ui);
+    expect(1, c == -1);
+    expect(1, uc == 255);
+    char *p = &c;
+    expectl(-1, *p);
+    unsigned char *up = &uc;
+    expectl(255, *up);
+}
+
+int test_conversion() {
+    unsigned int ui = 1;
+    int i = -1;
+    long l = -1;
+    expect(1, i < 1);
+    expect(0, i < ui);
+    expect(1, l < ui);
+    expectl(4294967295, i + 0u);
+    expectl(0, ui + i);
+    expectl(0, l + ui);
+    expectl(-2, l + i);
+    unsigned char a = 200;
+    unsigned char b = 100;
+    expect(300, a + b);
+    char c = -128;
+    expect(128, -c);
+    expectl(4294967295, (unsigned int)i | 0);
+    expectl(-1, i | 0);
+}
+
+int test_comparison() {
+    unsigned int a = 4000000000;
+    unsigned int b = 1;
+    expect(1, a > b);
+    expect(0, a < b);
+    expect(1, a >= b);
+    expect(0, a <= b);
+    unsigned long x = -1;
+    expect(1, x > 0);
+    long y = -1;
+    expect(1, y < 0);
+    expect(1, -1 < 0);
+    expect(0, -1 < 0u);
+    int *p = 0;
+    int *q = &b;
+    expect(1, p < q);
+    expect(1, p == 0);
+}
+
+int test_div_shift() {
+    unsigned int a = 4294967295;
+    int b = -1;
+    expectl(2147483647, a / 2);
+    expectl(1, a % 2);
+    expectl(0, b / 2);
+    expectl(-1, b % 2);
+    expectl(2147483647, a >> 1);
+    expectl(-1, b >> 1);
+    unsigned long c = -1;
+    expectl(9223372036854775807, c >> 1);
+    expectl(9223372036854775807, c / 2);
+    unsigned char d = 255;
+    expectl(-1, d << 24 >> 24);
+    expectl(255, (unsigned)d << 24 >> 24);
+    expectl(4294967040, ~d + 0u);
+}
+
+int test_literal() {
+    expect(4, sizeof(1u));
+    expect(8, sizeof(1ul));
+    expect(8, sizeof(1UL));
+    expect(8, sizeof(1ull));
+    expect(8, sizeof(4294967296u));
+    expect(8, sizeof(3000000000));
+    expect(4, sizeof(0xffffffff));
+    expectl(4294967295, 0xffffffff);
+    expectl(3000000000, 3000000000);
+    expectl(4294967295, 4294967295u);
+    expect(0, 0xffffffff < 0);
+    expect(0, 1u - 2 < 0);
+    expect(1, -1 > sizeof(int));
+}
+
+int main() {
+    test_load();
+    test_conversion();
+    test_comparison();
+    test_div_shift();
+    test_literal();
+    printf("OK\n");
+    return 0;
+}
@@ -20,3 +20,120 @@ void expect_string(char *a, char *b) {
         exit(1);
     }
 }
+
+/* Functions to test passing and returning structs across compilers. */
+
+struct abi_ii { int a; int b; };
+struct abi_iii { int a; int b; int c; };
+struct abi_ld { long a; double b; };
+struct abi_dd { double a; double b; };
+struct abi_ccc { char a; char b; char c; };
+struct abi_big { long a; long b; long c; };
+
+struct abi_ii abi_ii_make(int a, int b) {
+    struct abi_ii r = { a, b };
+    return r;
+}
+
+struct abi_iii abi_iii_make(int a, int b, int c) {
+    struct abi_iii r = { a, b, c };
+    return r;
+}
+
+struct abi_ld abi_ld_make(long a, double b) {
+    struct abi_ld r = { a, b };
+    return r;
+}
+
+struct abi_dd abi_dd_make(double a, double b) {
+    struct abi_dd r = { a, b };
+    return r;
+}
+
+struct abi_ccc abi_ccc_make(char a, char b, char c) {
+    struct abi_ccc r = { a, b, c };
+    return r;
+}
+
+struct abi_big abi_big_make(long a, long b, long c) {
+    struct abi_big r = { a, b, c };
+    return r;
+}
+
+long abi_sum(struct abi_ii ii, struct abi_iii iii, struct abi_ld ld,
+             struct abi_dd dd, struct abi_ccc ccc, struct abi_big big) {
+    return ii.a + ii.b + iii.a + iii.b + iii.c + ld.a + (long)ld.b +
+        (long)dd.a + (long)dd.b + ccc.a + ccc.b + ccc.c + big.a + big.b + big.c;
+}
+
+long abi_call(struct abi_ld (*fn)(struct abi_big, struct abi_dd)) {
+    struct abi_big big = { 1, 2, 3 };
+    struct abi_dd dd = { 4.5, 5.5 };
+    struct abi_ld r = fn(big, dd);
+    return r.a * 100 + (long)(r.b * 10);
+}
+
+/* Functions to test arguments passed on the stack. */
+
+long many_ints(long a, long b, long c, long d, long e, long f, long g, long h, long i) {
+    return a + b * 2 + c * 3 + d * 4 + e * 5 + f * 6 + g * 7 + h * 8 + i * 9;
+}
+
+double many_mixed(int a, double b, int c, double d, int e, double f, int g, double h,
+                  int i, double j, int k, double l, int m, double n, int o, double p,
+                  int q, double r) {
+    return a + b + c + d + e + f + g + h + i + j + k + l + m + n + o + p + q + r;
+}
+
+long call_many(long (*fn)(long, long, long, long, long, long, long, long, double, double,
+                          double, double, double, double, double, double, double, char)) {
+    return fn(1, 2, 3, 4, 5, 6, 7, 8, 0.5, 1.5, 2.5, 3.5, 4.5, 5.5, 6.5, 7.5, 8.5, 9);
+}
+
+/* A struct whose layout depends on the alignment of its members. */
+
+struct layout {
+    char c;
+    struct { char a[3]; short s; } n;
+    union { int i; char b[5]; };
+    long l;
+    int tail;
+};
+
+long layout_size(void) {
+    return sizeof(struct layout);
+}
+
+long layout_sum(struct layout *p) {
+    return p->c + p->n.a[2] + p->n.s + p->i + p->b[4] + p->l + p->tail;
+}
+
+long layout_byval(struct layout v) {
+    return layout_sum(&v);
+}
+
+struct abi_ff { float a; float b; };
+
+float abi_fmadd(float a, float b, float c) {
+    return a * b + c;
+}
+
+float abi_ff_sum(struct abi_ff v) {
+    return v.a + v.b;
+}
+
+float abi_fcall(float (*fn)(float, double)) {
+    return fn(1.5f, 2.25);
+}
+
+long double abi_ldmadd(long double a, long double b, long double c) {
+    return a * b + c;
+}
+
+long double abi_ldmix(int a, long double b, double c, long double d, int e) {
+    return a + b + c + d + e;
+}
+
+long double abi_ldcall(long double (*fn)(long double, double)) {
+    return fn(1.5L, 2.25);
+}
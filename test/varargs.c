@@ -0,0 +1,111 @@
+#include <stdarg.h>
+
+int vsprintf(char *buf, char *fmt, va_list ap);
+
+struct pair { long a; double b; };
+struct big { long a; long b; long c; };
+
+int sum_ints(int n, ...) {
+    va_list ap;
+    va_start(ap, n);
+    int r = 0;
+    for (int i = 0; i < n; i++)
+        r += va_arg(ap, int);
+    va_end(ap);
+    return r;
+}
+
+double sum_doubles(int n, ...) {
+    va_list ap;
+    va_start(ap, n);
+    double r = 0;
+    for (int i = 0; i < n; i++)
+        r = r + va_arg(ap, double);
+    va_end(ap);
+    return r;
+}
+
+long mixed(char *fmt, ...) {
+    va_list ap;
+    va_start(ap, fmt);
+    long r = 0;
+    for (char *p = fmt; *p; p++) {
+        if (*p == 'i') {
+            r = r * 10 + va_arg(ap, int);
+        } else if (*p == 'l') {
+            r = r * 10 + va_arg(ap, long);
+        } else if (*p == 'd') {
+            r = r * 10 + va_arg(ap, double);
+        } else if (*p == 'p') {
+            struct pair s = va_arg(ap, struct pair);
+            r = r * 10 + s.a + s.b;
+        } else if (*p == 'b') {
+            struct big s = va_arg(ap, struct big);
+            r = r * 10 + s.a + s.b + s.c;
+        }
+    }
+    va_end(ap);
+    return r;
+}
+
+char buf[100];
+
+void format(char *fmt, ...) {
+    va_list ap;
+    va_start(ap, fmt);
+    vsprintf(buf, fmt, ap);
+    va_end(ap);
+}
+
+int second(int n, ...) {
+    va_list ap;
+    va_list aq;
+    va_start(ap, n);
+    va_arg(ap, int);
+    va_copy(aq, ap);
+    int a = va_arg(ap, int);
+    int b = va_arg(aq, int);
+    va_end(ap);
+    va_end(aq);
+    return a * 10 + b;
+}
+
+int pass_list(va_list ap) {
+    return va_arg(ap, int);
+}
+
+int first_via(int n, ...) {
+    va_list ap;
+    va_start(ap, n);
+    int r = pass_list(ap);
+    r = r * 10 + pass_list(ap);
+    va_end(ap);
+    return r;
+}
+
+int main() {
+    expect(0, sum_ints(0));
+    expect(6, sum_ints(3, 1, 2, 3));
+    expect(55, sum_ints(10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10));
+    int r = sum_doubles(3, 1.5, 2.5, 3.0);
+    expect(7, r);
+    r = sum_doubles(10, 1.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 8.0, 9.0, 10.0);
+    expect(55, r);
+    struct pair p;
+    p.a = 1;
+    p.b = 2.0;
+    struct big b;
+    b.a = 1;
+    b.b = 2;
+    b.c = 3;
+    expect(123, mixed("ild", 1, 2L, 3.0));
+    expect(3695, mixed("pbid", p, b, 9, 5.0));
+    expect(123456789, mixed("iiiiiiiii", 1, 2, 3, 4, 5, 6, 7, 8, 9));
+    expect(3333, mixed("pppp", p, p, p, p));
+    format("%d %s %.1f %ld", 1, "two", 3.0, 4L);
+    expect_string("1 two 3.0 4", buf);
+    expect(33, second(3, 1, 3, 5));
+    expect(12, first_via(2, 1, 2));
+    printf("OK\n");
+    return 0;
+}
@@ -0,0 +1,108 @@
+typedef int wchar_t;
+typedef unsigned short char16_t;
+typedef unsigned int char32_t;
+
+#define STR(x) #x
+
+wchar_t gw[] = L"a\u00e9";
+char16_t *gu = u"x\U0001F600";
+char gu8[] = u8"\u00e9";
+
+void test_char() {
+    expect(97, L'a');
+    expect(233, L'é');
+    expect(12354, L'あ');
+    expect(12354, u'あ');
+    expect(128512, U'😀');
+    expect(128512, U'\U0001F600');
+    expect(233, L'\u00e9');
+    expect(4660, L'\x1234');
+    expect(10, L'\n');
+    expect(4, sizeof(L'a'));
+    expect(2, sizeof(u'a'));
+    expect(4, sizeof(U'a'));
+    expect(1, u'a' - 98 < 0);
+    expect(0, U'a' - 98 < 0);
+    expect(-1, L'\xffffffff');
+    expect(1, U'\xffffffff' == 4294967295);
+    expect(65535, u'\xffff');
+}
+
+void test_string() {
+    expect(16, sizeof(L"abc"));
+    expect(8, sizeof(u"abc"));
+    expect(16, sizeof(U"abc"));
+    expect(4, sizeof(u8"abc"));
+    expect(3, sizeof("é"));
+    expect(3, sizeof(u8"é"));
+    expect(8, sizeof(L"é"));
+    expect(4, sizeof(u"é"));
+    expect(6, sizeof(u"😀"));
+    expect(8, sizeof(U"😀"));
+    wchar_t *w = L"aあ😀";
+    expect('a', w[0]);
+    expect(12354, w[1]);
+    expect(128512, w[2]);
+    expect(0, w[3]);
+    char16_t *u = u"😀b";
+    expect(55357, u[0]);
+    expect(56832, u[1]);
+    expect('b', u[2]);
+    char32_t *U = U"\u3042\x10";
+    expect(12354, U[0]);
+    expect(16, U[1]);
+    char *s = "\u00e9";
+    expect(-61, s[0]);
+    expect(-87, s[1]);
+    expect(233, L"\351"[0]);
+    expect(56320, u"\xdc00"[0]);
+    expect(55296, L"\xd800"[0]);
+    expect(1114112, L"\x110000"[0]);
+    expect(1, U"\xffffffff"[0] == 4294967295);
+}
+
+void test_init() {
+    wchar_t a[] = L"xyz";
+    expect(4, sizeof(a) / sizeof(a[0]));
+    expect('y', a[1]);
+    expect(0, a[3]);
+    char16_t b[4] = u"ab";
+    expect('b', b[1]);
+    expect(0, b[3]);
+    char32_t c[] = { U"あ" };
+    expect(12354, c[0]);
+    expect(3, sizeof(gw) / sizeof(gw[0]));
+    expect(233, gw[1]);
+    expect(55357, gu[1]);
+    expect(0, gu[3]);
+    expect(3, sizeof(gu8));
+    expect(-61, gu8[0]);
+}
+
+void test_concat() {
+    wchar_t *w = L"a" "b" L"c";
+    expect('b', w[1]);
+    expect('c', w[2]);
+    expect(16, sizeof("a" L"b" "c"));
+    expect(6, sizeof(u"a" "\u3042"));
+    expect(255, (L"a" "\xff")[1]);
+    expect(256, (L"a" "\x100")[1]);
+    expect(233, (L"a" "é")[1]);
+    expect(233, ("é" U"")[0]);
+    expect(2, sizeof(u"" "\x1" "2") / sizeof(char16_t) - 1);
+    expect_string("L\"a\"", STR(L"a"));
+    expect_string("u8\"\\n\"", STR(u8"\n"));
+    expect_string("U'x'", STR(U'x'));
+    int L = 1, u8 = 2, U = 3, u = 4;
+    expect(10, L + u8 + U + u);
+}
+
+int main() {
+    printf("Testing wide ... ");
+    test_char();
+    test_string();
+    test_init();
+    test_concat();
+    printf("OK\n");
+    return 0;
+}
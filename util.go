@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 func errorf(format string, args ...interface{}) {
@@ -17,34 +19,87 @@ func assert(expr bool) {
 	}
 }
 
+// suppress_warning is set by -w.
+var suppress_warning bool
+
 func warn(format string, args ...interface{}) {
+	if suppress_warning {
+		return
+	}
 	fmt.Fprint(os.Stderr, "warning: ")
 	fmt.Fprintf(os.Stderr, format, args...)
 }
 
+// quote_cstring returns a string literal, without the quotes, that
+// stands for sval.
 func quote_cstring(sval string) string {
-	var s string
-	for _, c := range []byte(sval) {
-		if c == byte(0) {
-			break
+	return quote(sval, '"')
+}
+
+// quote_chars returns a string literal, without the quotes, that
+// stands for the code units in the encoding. Those of a wide string
+// that are not Unicode characters are written as hexadecimal escapes.
+func quote_chars(chars []int, enc int) string {
+	if !is_wide_enc(enc) {
+		b := make([]byte, len(chars))
+		for i, c := range chars {
+			b[i] = byte(c)
 		}
-		if c == '"' || c == '\\' {
-			s += fmt.Sprintf("\\%c", c)
-		} else if c == '\n' {
-			s += "\\n"
-		} else {
-			s += fmt.Sprintf("%c", c)
+		return quote_cstring(string(b))
+	}
+	var r string
+	for i := 0; i < len(chars); i++ {
+		c := chars[i]
+		if enc == ENC_CHAR16 && i+1 < len(chars) && utf16.IsSurrogate(rune(c)) {
+			if d := utf16.DecodeRune(rune(c), rune(chars[i+1])); d != utf8.RuneError {
+				c = int(d)
+				i++
+			}
+		}
+		switch {
+		case c < 0x80:
+			r += quote(string([]byte{byte(c)}), '"')
+		case c <= 0x10ffff && utf8.ValidRune(rune(c)):
+			r += string(rune(c))
+		default:
+			r += fmt.Sprintf("\\x%x", c)
 		}
 	}
-	return s
+	return r
 }
 
-func quote_char(c byte) string {
-	if c == '\\' {
-		return  "'\\\\"
+// enc_prefix returns the prefix of a literal in the encoding.
+func enc_prefix(enc int) string {
+	switch enc {
+	case ENC_CHAR16:
+		return "u"
+	case ENC_CHAR32:
+		return "U"
+	case ENC_UTF8:
+		return "u8"
+	case ENC_WCHAR:
+		return "L"
 	}
-	if c == '\'' {
-		return "'\\''"
+	return ""
+}
+
+// quote escapes the backslashes, the quote q and the control
+// characters in s. The bytes of UTF-8 are left as they are.
+func quote(s string, q byte) string {
+	var r string
+	for _, c := range []byte(s) {
+		switch {
+		case c == q || c == '\\':
+			r += fmt.Sprintf("\\%c", c)
+		case c == '\n':
+			r += "\\n"
+		case c == '\t':
+			r += "\\t"
+		case c < ' ' || c == 0x7f:
+			r += fmt.Sprintf("\\%03o", c)
+		default:
+			r += string([]byte{c})
+		}
 	}
-	return fmt.Sprintf("'%c'", c)
+	return r
 }